@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"github.com/voltrondata/pulumi-go-modules/AWS/eks"
+)
+
+type RunnersConfig struct {
+	// GitHub repository receiving the runners, in owner/repo-name format
+	Repository string
+	// Runner images with their tag, "<runner repository url>:latest" of the registry by default
+	LinuxImage   string
+	WindowsImage string
+}
+
+// GitHub repository in owner/repo-name format, which rules out the <owner>/<REPLACE-ME> placeholders
+var runnerRepositoryPattern = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+
+// awsAuthRole is a mapRoles entry of the aws-auth ConfigMap
+type awsAuthRole struct {
+	RoleArn  string   `json:"rolearn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups"`
+}
+
+// exportFluxVariables exports the values of the Flux manifests of the cluster as "flux-variables", rendered into
+// the cluster-vars ConfigMap by the fluxvars command and substituted by the Flux Kustomizations (postBuild)
+func exportFluxVariables(ctx *pulumi.Context, eksOutput eks.EksOutput, runnerRepositoryUrls map[string]pulumi.StringOutput, runnerCache *RunnerCacheOutput) error {
+
+	RunnersConfig := &RunnersConfig{}
+	conf := config.New(ctx, "")
+	conf.GetObject("Runners", &RunnersConfig)
+	// Without the runners, there is nothing for Flux to deploy yet, fluxvars then renders an empty ConfigMap
+	if RunnersConfig.Repository == "" {
+		return ctx.Log.Warn("runners: Runners.repository is not set, skipping the flux-variables export", nil)
+	}
+	if !runnerRepositoryPattern.MatchString(RunnersConfig.Repository) {
+		return fmt.Errorf("runners: repository %q is not a GitHub repository, set Runners.repository to the owner/repo-name receiving the runners", RunnersConfig.Repository)
+	}
+
+	runnerImages := map[string]string{
+		"linux":   RunnersConfig.LinuxImage,
+		"windows": RunnersConfig.WindowsImage,
+	}
+	variables := pulumi.StringMap{
+		"cluster_name":        eksOutput.EksClusterOutput.Name(),
+		"autoscaler_role_arn": eksOutput.AutoScalerRole.Arn,
+		"runner_repository":   pulumi.String(RunnersConfig.Repository),
+	}
+	for _, platform := range []string{"linux", "windows"} {
+		if runnerImages[platform] != "" {
+			variables[platform+"_runner_image"] = pulumi.String(runnerImages[platform])
+			continue
+		}
+		repositoryUrl, ok := runnerRepositoryUrls[platform]
+		if !ok {
+			return fmt.Errorf("runners: %sImage is required when the registry is disabled", platform)
+		}
+		variables[platform+"_runner_image"] = pulumi.Sprintf("%s:latest", repositoryUrl)
+	}
+	// The runners use the build cache through the IRSA role of their service account, the manifests default
+	// to a service account without role when the cache is disabled
+	if runnerCache != nil {
+		variables["runner_service_account"] = pulumi.String(runnerCache.ServiceAccount)
+		variables["runner_cache_role_arn"] = runnerCache.RoleArn
+		variables["runner_cache_bucket"] = runnerCache.Bucket
+	}
+
+	// The node roles join the cluster through aws-auth, sorted so the ConfigMap doesn't change between runs
+	nodeRoleArns := pulumi.StringArray{}
+	nodeRoleGroups := [][]string{}
+	linuxKeys := []string{}
+	for key := range eksOutput.LinuxNodeGroupRoles {
+		linuxKeys = append(linuxKeys, key)
+	}
+	sort.Strings(linuxKeys)
+	for _, key := range linuxKeys {
+		nodeRoleArns = append(nodeRoleArns, eksOutput.LinuxNodeGroupRoles[key].Arn)
+		nodeRoleGroups = append(nodeRoleGroups, []string{"system:bootstrappers", "system:nodes"})
+	}
+	windowsKeys := []string{}
+	for key := range eksOutput.WindowsNodeGroupRoles {
+		windowsKeys = append(windowsKeys, key)
+	}
+	sort.Strings(windowsKeys)
+	for _, key := range windowsKeys {
+		nodeRoleArns = append(nodeRoleArns, eksOutput.WindowsNodeGroupRoles[key].Arn)
+		nodeRoleGroups = append(nodeRoleGroups, []string{"system:bootstrappers", "system:nodes", "eks:kube-proxy-windows"})
+	}
+	// Rendered as JSON, so it fits on the single line Flux substitutes in mapRoles
+	variables["aws_auth_map_roles"] = nodeRoleArns.ToStringArrayOutput().ApplyT(func(roleArns []string) (string, error) {
+		mapRoles := []awsAuthRole{}
+		for index, roleArn := range roleArns {
+			mapRoles = append(mapRoles, awsAuthRole{
+				RoleArn:  roleArn,
+				Username: "system:node:{{EC2PrivateDNSName}}",
+				Groups:   nodeRoleGroups[index],
+			})
+		}
+		mapRolesJson, err := json.Marshal(mapRoles)
+		return string(mapRolesJson), err
+	}).(pulumi.StringOutput)
+
+	ctx.Export("flux-variables", variables)
+	return nil
+}
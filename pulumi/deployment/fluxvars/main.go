@@ -0,0 +1,96 @@
+// Command fluxvars renders the "flux-variables" output of a stack into the cluster-vars ConfigMap, substituted by the
+// Flux Kustomizations of the cluster in place of the ${...} variables of the manifests.
+//
+//	go run ./fluxvars -stack staging -output ../../fluxcd/clusters/staging/cluster-vars.yaml
+//
+// With -check, the file is left as is and the command fails when it doesn't match the stack. A stack without the
+// output (Runners.repository not set) renders a ConfigMap without data.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+)
+
+func errorHandler(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fluxvars:", err)
+		os.Exit(1)
+	}
+}
+
+// stackVariables reads the flux-variables output of a stack with the Pulumi CLI, empty when the stack doesn't export it
+func stackVariables(stack string, dir string) (map[string]string, error) {
+	command := exec.Command("pulumi", "stack", "output", "--json", "--stack", stack)
+	command.Dir = dir
+	command.Stderr = os.Stderr
+	output, err := command.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pulumi stack output: %v", err)
+	}
+	outputs := struct {
+		FluxVariables map[string]string `json:"flux-variables"`
+	}{}
+	if err := json.Unmarshal(output, &outputs); err != nil {
+		return nil, fmt.Errorf("flux-variables of stack %s: %v", stack, err)
+	}
+	if len(outputs.FluxVariables) == 0 {
+		fmt.Fprintf(os.Stderr, "fluxvars: stack %s has no flux-variables, set Runners.repository and run pulumi up\n", stack)
+	}
+	return outputs.FluxVariables, nil
+}
+
+// renderConfigMap renders the variables as the cluster-vars ConfigMap, sorted so the file only changes with them
+func renderConfigMap(stack string, variables map[string]string) ([]byte, error) {
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var configMap bytes.Buffer
+	fmt.Fprintf(&configMap, "# Generated from the outputs of the %s stack by pulumi/deployment/fluxvars, do not edit\n", stack)
+	configMap.WriteString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cluster-vars\n  namespace: flux-system\n")
+	if len(names) == 0 {
+		configMap.WriteString("data: {}\n")
+		return configMap.Bytes(), nil
+	}
+	configMap.WriteString("data:\n")
+	for _, name := range names {
+		// JSON strings are valid double-quoted YAML scalars
+		value, err := json.Marshal(variables[name])
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&configMap, "  %s: %s\n", name, value)
+	}
+	return configMap.Bytes(), nil
+}
+
+func main() {
+	stack := flag.String("stack", "staging", "stack to read the outputs from")
+	dir := flag.String("dir", ".", "directory of the Pulumi project")
+	output := flag.String("output", "../../fluxcd/clusters/staging/cluster-vars.yaml", "ConfigMap file to write")
+	check := flag.Bool("check", false, "fail if the ConfigMap file doesn't match the stack, instead of writing it")
+	flag.Parse()
+
+	variables, err := stackVariables(*stack, *dir)
+	errorHandler(err)
+	configMap, err := renderConfigMap(*stack, variables)
+	errorHandler(err)
+
+	if *check {
+		current, err := os.ReadFile(*output)
+		errorHandler(err)
+		if !bytes.Equal(current, configMap) {
+			errorHandler(fmt.Errorf("%s doesn't match the outputs of the %s stack, run go run ./fluxvars -stack %s", *output, *stack, *stack))
+		}
+		return
+	}
+	errorHandler(os.WriteFile(*output, configMap, 0644))
+}
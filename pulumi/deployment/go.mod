@@ -4,13 +4,17 @@ go 1.18
 
 replace github.com/voltrondata/pulumi-go-modules/AWS/eks => ../modules/eks
 
+replace github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder => ../modules/imagebuilder
+
 replace github.com/voltrondata/pulumi-go-modules/AWS/vpc => ../modules/vpc
 
 replace github.com/voltrondata/pulumi-go-modules/shared/utilities => ../modules/utilities
 
 require (
+	github.com/pulumi/pulumi-aws/sdk/v5 v5.42.0
 	github.com/pulumi/pulumi/sdk/v3 v3.80.0
 	github.com/voltrondata/pulumi-go-modules/AWS/eks v0.1.3
+	github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder v0.1.0
 	github.com/voltrondata/pulumi-go-modules/AWS/vpc v0.1.1
 )
 
@@ -61,7 +65,6 @@ require (
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pkg/term v1.1.0 // indirect
-	github.com/pulumi/pulumi-aws/sdk/v5 v5.42.0 // indirect
 	github.com/pulumi/pulumi-eks/sdk v0.42.7 // indirect
 	github.com/pulumi/pulumi-kubernetes/sdk/v3 v3.17.0 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 	"github.com/voltrondata/pulumi-go-modules/AWS/eks"
+	"github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder"
 	"github.com/voltrondata/pulumi-go-modules/AWS/vpc"
 )
 
@@ -23,7 +25,36 @@ func main() {
 		errorHandler(err)
 
 		//Create the EKS cluster
-		_, err = eks.CreateEKSCluster(ctx, vpcOutput.Vpc, vpcOutput.PrivateSubnets)
+		// Subnet tiers the node groups can be placed in
+		nodeSubnets := map[string]eks.NodeSubnets{
+			"private": {Subnets: vpcOutput.PrivateSubnets, AvailabilityZones: vpcOutput.PrivateSubnetsAZ},
+			"public":  {Subnets: vpcOutput.PublicSubnets, AvailabilityZones: vpcOutput.PublicSubnetsAZ},
+			"runner":  {Subnets: vpcOutput.RunnerSubnets, AvailabilityZones: vpcOutput.RunnerSubnetsAZ},
+		}
+		eksOutput, err := eks.CreateEKSCluster(ctx, vpcOutput.Vpc, vpcOutput.PrivateSubnets, vpcOutput.PodSubnets, nodeSubnets)
+		errorHandler(err)
+
+		//Create the S3 build cache of the runners
+		runnerCache, err := createRunnerCache(ctx, eksOutput.EksClusterOutput, vpcOutput.S3GatewayEndpoint)
+		errorHandler(err)
+
+		//Create the ECR repositories of the runner images and the pull-through cache
+		nodeRoles := map[string]*iam.Role{}
+		for key, role := range eksOutput.LinuxNodeGroupRoles {
+			nodeRoles["linux-"+key] = role
+		}
+		for key, role := range eksOutput.WindowsNodeGroupRoles {
+			nodeRoles["windows-"+key] = role
+		}
+		runnerRepositoryUrls, err := createRegistry(ctx, nodeRoles)
+		errorHandler(err)
+
+		//Create the Image Builder pipelines of the node AMIs
+		_, err = imagebuilder.CreateImagePipelines(ctx, vpcOutput.Vpc, vpcOutput.PrivateSubnets)
+		errorHandler(err)
+
+		//Export the values of the Flux manifests
+		err = exportFluxVariables(ctx, eksOutput, runnerRepositoryUrls, runnerCache)
 		errorHandler(err)
 		return nil
 	})
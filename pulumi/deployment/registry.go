@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudcontrol"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ecr"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/secretsmanager"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+type RegistryConfig struct {
+	Enabled bool
+	// Repository of the Linux runner image, "gha-runners/ubuntu-2004" by default
+	LinuxRepository string
+	// Repository of the Windows runner image, "gha-runners/windows-server-2019" by default
+	WindowsRepository string
+	// Number of images kept per repository, 30 by default
+	KeepImages int
+	// Days before untagged images are deleted, 7 by default
+	UntaggedExpirationDays int
+	// Pull-through cache rules, keyed by repository prefix. Only ecr-public (public.ecr.aws) by default
+	PullThroughCache map[string]string
+	Tags             map[string]string
+}
+
+// Credentials of an upstream registry, from the RegistryCredentials secret config keyed by repository prefix
+type RegistryCredentials struct {
+	Username string `json:"username"`
+	// GitHub or Docker Hub access token
+	AccessToken string `json:"accessToken"`
+}
+
+// Upstream registries that need credentials, with their name in the ECR API
+var authenticatedUpstreams = map[string]string{
+	"ghcr.io":              "github-container-registry",
+	"registry-1.docker.io": "docker-hub",
+}
+
+// createRegistry creates the private ECR repositories of the runner images, with scan on push and a lifecycle policy,
+// and the pull-through cache rules, allowing the nodes to fill the cache on the first pull.
+// The repository URLs are exported, and returned by platform for the RunnerDeployments.
+func createRegistry(ctx *pulumi.Context, nodeRoles map[string]*iam.Role) (map[string]pulumi.StringOutput, error) {
+
+	RegistryConfig := &RegistryConfig{}
+	conf := config.New(ctx, "")
+	conf.GetObject("Registry", &RegistryConfig)
+	if !RegistryConfig.Enabled {
+		return nil, nil
+	}
+
+	linuxRepository := RegistryConfig.LinuxRepository
+	if linuxRepository == "" {
+		linuxRepository = "gha-runners/ubuntu-2004"
+	}
+	windowsRepository := RegistryConfig.WindowsRepository
+	if windowsRepository == "" {
+		windowsRepository = "gha-runners/windows-server-2019"
+	}
+	keepImages := RegistryConfig.KeepImages
+	if keepImages == 0 {
+		keepImages = 30
+	}
+	untaggedExpirationDays := RegistryConfig.UntaggedExpirationDays
+	if untaggedExpirationDays == 0 {
+		untaggedExpirationDays = 7
+	}
+	pullThroughCache := RegistryConfig.PullThroughCache
+	if pullThroughCache == nil {
+		pullThroughCache = map[string]string{"ecr-public": "public.ecr.aws"}
+	}
+	registryCredentials := map[string]RegistryCredentials{}
+	if _, err := conf.TrySecretObject("RegistryCredentials", &registryCredentials); err != nil && !errors.Is(err, config.ErrMissingVar) {
+		return nil, fmt.Errorf("registry: RegistryCredentials: %v", err)
+	}
+	for prefix, upstream := range pullThroughCache {
+		if _, ok := authenticatedUpstreams[upstream]; !ok {
+			continue
+		}
+		credentials := registryCredentials[prefix]
+		if credentials.Username == "" || credentials.AccessToken == "" {
+			return nil, fmt.Errorf("registry: pull-through cache %s needs the username and accessToken of %s in the RegistryCredentials secret config", prefix, upstream)
+		}
+	}
+	CommonTags := pulumi.StringMap{}
+	for index, tag := range RegistryConfig.Tags {
+		CommonTags[index] = pulumi.String(tag)
+	}
+
+	lifecyclePolicyJson, err := json.Marshal(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			map[string]interface{}{
+				"rulePriority": 1,
+				"description":  fmt.Sprintf("Expire untagged images after %d days", untaggedExpirationDays),
+				"selection": map[string]interface{}{
+					"tagStatus":   "untagged",
+					"countType":   "sinceImagePushed",
+					"countUnit":   "days",
+					"countNumber": untaggedExpirationDays,
+				},
+				"action": map[string]interface{}{"type": "expire"},
+			},
+			map[string]interface{}{
+				"rulePriority": 2,
+				"description":  fmt.Sprintf("Keep the last %d images", keepImages),
+				"selection": map[string]interface{}{
+					"tagStatus":   "any",
+					"countType":   "imageCountMoreThan",
+					"countNumber": keepImages,
+				},
+				"action": map[string]interface{}{"type": "expire"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runnerRepositoryUrls := map[string]pulumi.StringOutput{}
+	runnerRepositories := map[string]string{
+		"linux":   linuxRepository,
+		"windows": windowsRepository,
+	}
+	for platform, repositoryName := range runnerRepositories {
+		repository, err := ecr.NewRepository(ctx, platform+"-runner-repository", &ecr.RepositoryArgs{
+			Name:               pulumi.String(repositoryName),
+			ImageTagMutability: pulumi.String("MUTABLE"),
+			ImageScanningConfiguration: &ecr.RepositoryImageScanningConfigurationArgs{
+				ScanOnPush: pulumi.Bool(true),
+			},
+			Tags: CommonTags,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ecr.NewLifecyclePolicy(ctx, platform+"-runner-repository-lifecycle", &ecr.LifecyclePolicyArgs{
+			Repository: repository.Name,
+			Policy:     pulumi.String(lifecyclePolicyJson),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Export(platform+"-runner-repository-url", repository.RepositoryUrl)
+		runnerRepositoryUrls[platform] = repository.RepositoryUrl
+	}
+
+	if len(pullThroughCache) == 0 {
+		return runnerRepositoryUrls, nil
+	}
+
+	prefixes := []string{}
+	for prefix := range pullThroughCache {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if _, ok := authenticatedUpstreams[pullThroughCache[prefix]]; ok {
+			err := createAuthenticatedPullThroughCacheRule(ctx, prefix, pullThroughCache[prefix], registryCredentials[prefix], CommonTags)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		_, err := ecr.NewPullThroughCacheRule(ctx, prefix+"-pull-through-cache", &ecr.PullThroughCacheRuleArgs{
+			EcrRepositoryPrefix: pulumi.String(prefix),
+			UpstreamRegistryUrl: pulumi.String(pullThroughCache[prefix]),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The first pull of an image creates its repository in the cache, on behalf of the node pulling it
+	currentCaller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	currentRegion, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Export("ecr-registry-url", pulumi.String(fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", currentCaller.AccountId, currentRegion.Name)))
+
+	cacheRepositories := []string{}
+	for _, prefix := range prefixes {
+		cacheRepositories = append(cacheRepositories, fmt.Sprintf("arn:aws:ecr:%s:%s:repository/%s/*", currentRegion.Name, currentCaller.AccountId, prefix))
+	}
+	pullThroughCachePolicyJson, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			map[string]interface{}{
+				"Action": []string{
+					"ecr:CreateRepository",
+					"ecr:BatchImportUpstreamImage",
+				},
+				"Effect":   "Allow",
+				"Resource": cacheRepositories,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pullThroughCachePolicy, err := iam.NewPolicy(ctx, "ecr-pull-through-cache-policy", &iam.PolicyArgs{
+		Name:        pulumi.String(ctx.Project() + "-" + ctx.Stack() + "-ecr-pull-through-cache"),
+		Description: pulumi.String("Allow the nodes to fill the ECR pull-through cache"),
+		Path:        pulumi.String("/"),
+		Policy:      pulumi.String(pullThroughCachePolicyJson),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, nodeRole := range nodeRoles {
+		_, err := iam.NewRolePolicyAttachment(ctx, "ecr-pull-through-cache-rpa-"+key, &iam.RolePolicyAttachmentArgs{
+			Role:      nodeRole.Name,
+			PolicyArn: pullThroughCachePolicy.Arn,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runnerRepositoryUrls, nil
+}
+
+// createAuthenticatedPullThroughCacheRule creates a pull-through cache rule of an upstream registry that needs credentials.
+// ECR reads them from a Secrets Manager secret named ecr-pullthroughcache/<prefix>. The rule is created through
+// Cloud Control, since the credentials of the rules are only part of ecr.PullThroughCacheRule from pulumi-aws v6 on.
+func createAuthenticatedPullThroughCacheRule(ctx *pulumi.Context, prefix string, upstream string, credentials RegistryCredentials, CommonTags pulumi.StringMap) error {
+	secret, err := secretsmanager.NewSecret(ctx, prefix+"-pull-through-cache-credentials", &secretsmanager.SecretArgs{
+		Name:        pulumi.String("ecr-pullthroughcache/" + prefix),
+		Description: pulumi.String("Credentials of " + upstream + " for the " + prefix + " ECR pull-through cache"),
+		Tags:        CommonTags,
+	})
+	if err != nil {
+		return err
+	}
+
+	credentialsJson, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+	secretVersion, err := secretsmanager.NewSecretVersion(ctx, prefix+"-pull-through-cache-credentials", &secretsmanager.SecretVersionArgs{
+		SecretId:     secret.ID(),
+		SecretString: pulumi.ToSecret(pulumi.String(credentialsJson)).(pulumi.StringOutput),
+	})
+	if err != nil {
+		return err
+	}
+
+	desiredState := secret.Arn.ApplyT(func(secretArn string) (string, error) {
+		desiredStateJson, err := json.Marshal(map[string]interface{}{
+			"EcrRepositoryPrefix": prefix,
+			"UpstreamRegistry":    authenticatedUpstreams[upstream],
+			"UpstreamRegistryUrl": upstream,
+			"CredentialArn":       secretArn,
+		})
+		return string(desiredStateJson), err
+	}).(pulumi.StringOutput)
+
+	_, err = cloudcontrol.NewResource(ctx, prefix+"-pull-through-cache", &cloudcontrol.ResourceArgs{
+		TypeName:     pulumi.String("AWS::ECR::PullThroughCacheRule"),
+		DesiredState: desiredState,
+	}, pulumi.DependsOn([]pulumi.Resource{secretVersion}))
+	return err
+}
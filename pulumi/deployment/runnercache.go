@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	awseks "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+	"github.com/voltrondata/pulumi-go-modules/AWS/eks"
+)
+
+type RunnerCacheConfig struct {
+	Enabled bool
+	// Days before the cache objects are deleted, 14 when 0
+	ExpirationDays int
+	// Service account of the runners, in the actions-runner-system namespace. "github-runner" by default
+	ServiceAccount string
+	Tags           map[string]string
+}
+
+// Namespace of the runners, as deployed by Flux
+const runnerNamespace = "actions-runner-system"
+
+// RunnerCacheOutput is what the runner manifests need to use the cache, through the Flux variables
+type RunnerCacheOutput struct {
+	ServiceAccount string
+	Bucket         pulumi.StringOutput
+	RoleArn        pulumi.StringOutput
+}
+
+// createRunnerCache creates the S3 bucket shared by the runners for their build caches (ccache, sccache...),
+// only reachable through the S3 gateway endpoint of the VPC, and the IRSA role of the runner service account
+// that can read and write it. It returns nil when the cache is disabled.
+func createRunnerCache(ctx *pulumi.Context, eksCluster awseks.ClusterOutput, s3Endpoint *ec2.VpcEndpoint) (*RunnerCacheOutput, error) {
+
+	RunnerCacheConfig := &RunnerCacheConfig{}
+	conf := config.New(ctx, "")
+	conf.GetObject("RunnerCache", &RunnerCacheConfig)
+	if !RunnerCacheConfig.Enabled {
+		return nil, nil
+	}
+
+	expirationDays := RunnerCacheConfig.ExpirationDays
+	if expirationDays == 0 {
+		expirationDays = 14
+	}
+	serviceAccount := RunnerCacheConfig.ServiceAccount
+	if serviceAccount == "" {
+		serviceAccount = "github-runner"
+	}
+	name := ctx.Project() + "-" + ctx.Stack()
+	CommonTags := pulumi.StringMap{}
+	for index, tag := range RunnerCacheConfig.Tags {
+		CommonTags[index] = pulumi.String(tag)
+	}
+
+	bucket, err := s3.NewBucketV2(ctx, "runner-cache-bucket", &s3.BucketV2Args{
+		BucketPrefix: pulumi.String(name + "-runner-cache-"),
+		Tags:         CommonTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s3.NewBucketPublicAccessBlock(ctx, "runner-cache-bucket-public-access-block", &s3.BucketPublicAccessBlockArgs{
+		Bucket:                bucket.ID(),
+		BlockPublicAcls:       pulumi.Bool(true),
+		BlockPublicPolicy:     pulumi.Bool(true),
+		IgnorePublicAcls:      pulumi.Bool(true),
+		RestrictPublicBuckets: pulumi.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s3.NewBucketServerSideEncryptionConfigurationV2(ctx, "runner-cache-bucket-encryption", &s3.BucketServerSideEncryptionConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketServerSideEncryptionConfigurationV2RuleArray{
+			&s3.BucketServerSideEncryptionConfigurationV2RuleArgs{
+				ApplyServerSideEncryptionByDefault: &s3.BucketServerSideEncryptionConfigurationV2RuleApplyServerSideEncryptionByDefaultArgs{
+					SseAlgorithm: pulumi.String("AES256"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Caches are rebuilt when missing, so old entries are simply dropped
+	_, err = s3.NewBucketLifecycleConfigurationV2(ctx, "runner-cache-bucket-lifecycle", &s3.BucketLifecycleConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketLifecycleConfigurationV2RuleArray{
+			&s3.BucketLifecycleConfigurationV2RuleArgs{
+				Id:     pulumi.String("expire-runner-cache"),
+				Status: pulumi.String("Enabled"),
+				Filter: &s3.BucketLifecycleConfigurationV2RuleFilterArgs{},
+				Expiration: &s3.BucketLifecycleConfigurationV2RuleExpirationArgs{
+					Days: pulumi.Int(expirationDays),
+				},
+				AbortIncompleteMultipartUpload: &s3.BucketLifecycleConfigurationV2RuleAbortIncompleteMultipartUploadArgs{
+					DaysAfterInitiation: pulumi.Int(1),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects can only be read and written through the S3 gateway endpoint, so the cache traffic never goes through the NAT
+	bucketPolicyJson := pulumi.All(bucket.Arn, s3Endpoint.ID()).ApplyT(func(args []interface{}) (string, error) {
+		bucketArn := args[0].(string)
+		s3EndpointId := string(args[1].(pulumi.ID))
+		policyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Sid":       "DenyOutsideVpcEndpoint",
+					"Effect":    "Deny",
+					"Principal": "*",
+					"Action": []string{
+						"s3:GetObject",
+						"s3:PutObject",
+						"s3:DeleteObject",
+					},
+					"Resource": bucketArn + "/*",
+					"Condition": map[string]interface{}{
+						"StringNotEquals": map[string]interface{}{
+							"aws:SourceVpce": s3EndpointId,
+						},
+					},
+				},
+				map[string]interface{}{
+					"Sid":       "DenyInsecureTransport",
+					"Effect":    "Deny",
+					"Principal": "*",
+					"Action":    "s3:*",
+					"Resource":  []string{bucketArn, bucketArn + "/*"},
+					"Condition": map[string]interface{}{
+						"Bool": map[string]interface{}{
+							"aws:SecureTransport": "false",
+						},
+					},
+				},
+			},
+		})
+		return string(policyJson), err
+	}).(pulumi.StringOutput)
+
+	_, err = s3.NewBucketPolicy(ctx, "runner-cache-bucket-policy", &s3.BucketPolicyArgs{
+		Bucket: bucket.ID(),
+		Policy: bucketPolicyJson,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	runnerCacheRole, err := eks.NewIrsaRole(ctx, name+"-runner-cache-role", eksCluster, runnerNamespace, serviceAccount, nil, CommonTags)
+	if err != nil {
+		return nil, err
+	}
+
+	runnerCachePolicyJson := bucket.Arn.ApplyT(func(bucketArn string) (string, error) {
+		policyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Action": []string{
+						"s3:ListBucket",
+						"s3:GetBucketLocation",
+					},
+					"Effect":   "Allow",
+					"Resource": bucketArn,
+				},
+				map[string]interface{}{
+					"Action": []string{
+						"s3:GetObject",
+						"s3:PutObject",
+						"s3:DeleteObject",
+						"s3:AbortMultipartUpload",
+					},
+					"Effect":   "Allow",
+					"Resource": bucketArn + "/*",
+				},
+			},
+		})
+		return string(policyJson), err
+	}).(pulumi.StringOutput)
+
+	_, err = iam.NewRolePolicy(ctx, "runner-cache-role-policy", &iam.RolePolicyArgs{
+		Role:   runnerCacheRole.Name,
+		Policy: runnerCachePolicyJson,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export("runner-cache-bucket", bucket.ID())
+	ctx.Export("runner-cache-bucket-arn", bucket.Arn)
+	ctx.Export("runner-cache-role-arn", runnerCacheRole.Arn)
+	return &RunnerCacheOutput{
+		ServiceAccount: serviceAccount,
+		Bucket:         bucket.ID().ToStringOutput(),
+		RoleArn:        runnerCacheRole.Arn,
+	}, nil
+}
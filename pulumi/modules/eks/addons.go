@@ -0,0 +1,301 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	awseks "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	eks "github.com/pulumi/pulumi-eks/sdk/go/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type AddonConfig struct {
+	// vpc-cni, coredns, kube-proxy, aws-ebs-csi-driver, eks-pod-identity-agent...
+	Name string
+	// Exact version (v1.15.1-eksbuild.1), "latest" (default) for the most recent version compatible with the cluster,
+	// or "default" for the version EKS installs on new clusters
+	Version string
+	// OVERWRITE (default), PRESERVE or NONE
+	ResolveConflicts string
+	// Create an IRSA role for the service account of the add-on
+	Irsa bool
+	// "namespace/name" of the service account, known for vpc-cni and aws-ebs-csi-driver
+	ServiceAccount string
+	// Policies of the IRSA role, known for vpc-cni and aws-ebs-csi-driver
+	IrsaPolicyArns []string
+	// Configuration values, as documented by `aws eks describe-addon-configuration`
+	ConfigurationValues map[string]interface{}
+}
+
+// Service account and policies of the IRSA role of the add-ons that need AWS permissions
+var addonIrsaDefaults = map[string]struct {
+	serviceAccount string
+	policyArns     []string
+}{
+	"vpc-cni": {
+		serviceAccount: "kube-system/aws-node",
+		policyArns:     []string{"arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy"},
+	},
+	"aws-ebs-csi-driver": {
+		serviceAccount: "kube-system/ebs-csi-controller-sa",
+		policyArns:     []string{"arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy"},
+	},
+}
+
+// Add-ons that don't need running nodes to become active, created before the node groups so the nodes join with them.
+// The others (coredns, aws-ebs-csi-driver) wait for the Linux node groups.
+var addonsBeforeNodes = map[string]bool{
+	"vpc-cni":                true,
+	"kube-proxy":             true,
+	"eks-pod-identity-agent": true,
+}
+
+// resolveAddonVersions checks the configured add-ons and returns the version of each one, resolving "latest" and "default".
+// Pinned versions are checked against the Kubernetes version of the cluster before anything is applied: they have to be
+// in the versions EKS lists as compatible with it.
+func resolveAddonVersions(ctx *pulumi.Context, EksConfig *EksConfig) (map[string]string, error) {
+	addonVersions := map[string]string{}
+	for _, addon := range EksConfig.Addons {
+		if _, ok := addonVersions[addon.Name]; ok {
+			return nil, fmt.Errorf("eks %s: add-on %s is configured twice", EksConfig.Name, addon.Name)
+		}
+		switch addon.ResolveConflicts {
+		case "", "OVERWRITE", "PRESERVE", "NONE":
+		default:
+			return nil, fmt.Errorf("eks %s: unknown resolveConflicts %q for add-on %s, must be OVERWRITE, PRESERVE or NONE", EksConfig.Name, addon.ResolveConflicts, addon.Name)
+		}
+		if addon.Irsa {
+			if _, ok := addonIrsaDefaults[addon.Name]; !ok && (addon.ServiceAccount == "" || len(addon.IrsaPolicyArns) == 0) {
+				return nil, fmt.Errorf("eks %s: add-on %s needs serviceAccount and irsaPolicyArns for its IRSA role", EksConfig.Name, addon.Name)
+			}
+			if addon.ServiceAccount != "" && len(strings.Split(addon.ServiceAccount, "/")) != 2 {
+				return nil, fmt.Errorf("eks %s: serviceAccount of add-on %s must be namespace/name", EksConfig.Name, addon.Name)
+			}
+		}
+
+		compatibleVersions := map[bool]string{}
+		for _, mostRecent := range []bool{true, false} {
+			mostRecent := mostRecent
+			compatibleVersion, err := awseks.GetAddonVersion(ctx, &awseks.GetAddonVersionArgs{
+				AddonName:         addon.Name,
+				KubernetesVersion: EksConfig.Version,
+				MostRecent:        &mostRecent,
+			}, nil)
+			if err != nil {
+				return nil, fmt.Errorf("eks %s: add-on %s is not available for Kubernetes %s: %w", EksConfig.Name, addon.Name, EksConfig.Version, err)
+			}
+			compatibleVersions[mostRecent] = compatibleVersion.Version
+		}
+		latestVersion, defaultVersion := compatibleVersions[true], compatibleVersions[false]
+
+		switch addon.Version {
+		case "", "latest":
+			addonVersions[addon.Name] = latestVersion
+		case "default":
+			addonVersions[addon.Name] = defaultVersion
+		default:
+			compatibleVersions, err := listCompatibleAddonVersions(ctx, addon.Name, EksConfig.Version)
+			if err != nil {
+				return nil, fmt.Errorf("eks %s: can't list the versions of add-on %s: %w", EksConfig.Name, addon.Name, err)
+			}
+			if !isCompatibleAddonVersion(addon.Version, compatibleVersions) {
+				return nil, fmt.Errorf("eks %s: add-on %s %s is not compatible with Kubernetes %s, the compatible versions are %s", EksConfig.Name, addon.Name, addon.Version, EksConfig.Version, strings.Join(compatibleVersions, ", "))
+			}
+			addonVersions[addon.Name] = addon.Version
+		}
+	}
+	return addonVersions, nil
+}
+
+// listCompatibleAddonVersions lists the versions of an add-on compatible with a Kubernetes version, most recent first.
+// The provider only looks up one version, so the list comes from the AWS CLI (describe-addon-versions).
+func listCompatibleAddonVersions(ctx *pulumi.Context, addonName string, kubernetesVersion string) ([]string, error) {
+	region, err := aws.GetRegion(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	command := exec.Command("aws", "eks", "describe-addon-versions", "--addon-name", addonName, "--kubernetes-version", kubernetesVersion, "--region", region.Name, "--output", "json")
+	output, err := command.Output()
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("aws eks describe-addon-versions: %v: %s", err, strings.TrimSpace(string(exitError.Stderr)))
+		}
+		return nil, fmt.Errorf("aws eks describe-addon-versions: %v", err)
+	}
+	return parseAddonVersions(output)
+}
+
+// parseAddonVersions reads the versions out of the describe-addon-versions output, most recent first
+func parseAddonVersions(output []byte) ([]string, error) {
+	describeAddonVersions := struct {
+		Addons []struct {
+			AddonVersions []struct {
+				AddonVersion string `json:"addonVersion"`
+			} `json:"addonVersions"`
+		} `json:"addons"`
+	}{}
+	if err := json.Unmarshal(output, &describeAddonVersions); err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, addon := range describeAddonVersions.Addons {
+		for _, addonVersion := range addon.AddonVersions {
+			versions = append(versions, addonVersion.AddonVersion)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareAddonVersions(versions[i], versions[j]) > 0
+	})
+	return versions, nil
+}
+
+// isCompatibleAddonVersion tells if the pinned version is one of the compatible versions
+func isCompatibleAddonVersion(version string, compatibleVersions []string) bool {
+	for _, compatibleVersion := range compatibleVersions {
+		if version == compatibleVersion {
+			return true
+		}
+	}
+	return false
+}
+
+// compareAddonVersions compares two add-on versions like v1.15.1-eksbuild.1, returning -1, 0 or 1
+func compareAddonVersions(a string, b string) int {
+	parse := func(version string) []int {
+		numbers := []int{}
+		for _, field := range strings.FieldsFunc(strings.TrimPrefix(version, "v"), func(r rune) bool {
+			return r < '0' || r > '9'
+		}) {
+			number, _ := strconv.Atoi(field)
+			numbers = append(numbers, number)
+		}
+		return numbers
+	}
+	aNumbers, bNumbers := parse(a), parse(b)
+	for i := 0; i < len(aNumbers) && i < len(bNumbers); i++ {
+		if aNumbers[i] != bNumbers[i] {
+			if aNumbers[i] < bNumbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(aNumbers) < len(bNumbers):
+		return -1
+	case len(aNumbers) > len(bNumbers):
+		return 1
+	}
+	return 0
+}
+
+// hasAddon tells if the add-on is managed by the module
+func hasAddon(EksConfig *EksConfig, name string) bool {
+	for _, addon := range EksConfig.Addons {
+		if addon.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// vpcCniEnv returns the environment of the VPC CNI for the custom networking and prefix delegation settings,
+// set through the vpc-cni add-on when it is managed by the module
+func vpcCniEnv(EksConfig *EksConfig, customNetworking bool) map[string]interface{} {
+	env := map[string]interface{}{}
+	if customNetworking {
+		env["AWS_VPC_K8S_CNI_CUSTOM_NETWORK_CFG"] = "true"
+		env["ENI_CONFIG_LABEL_DEF"] = "topology.kubernetes.io/zone"
+	}
+	if EksConfig.EnablePrefixDelegation {
+		env["ENABLE_PREFIX_DELEGATION"] = "true"
+		env["WARM_PREFIX_TARGET"] = "1"
+	}
+	return env
+}
+
+// createAddons creates the managed add-ons of one phase: the ones needed before the nodes join, or the ones
+// that wait for the nodes. During an upgrade the nodes already run, so all the add-ons are upgraded before them.
+// It returns them keyed by name.
+func createAddons(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, eksCluster *eks.Cluster, addonVersions map[string]string, customNetworking bool, cniIpv6Policy *iam.Policy, upgrade bool, beforeNodes bool, dependsOn []pulumi.Resource) (map[string]*awseks.Addon, error) {
+	addons := map[string]*awseks.Addon{}
+	for _, addon := range EksConfig.Addons {
+		if (upgrade || addonsBeforeNodes[addon.Name]) != beforeNodes {
+			continue
+		}
+
+		resolveConflicts := addon.ResolveConflicts
+		if resolveConflicts == "" {
+			resolveConflicts = "OVERWRITE"
+		}
+
+		configurationValues := map[string]interface{}{}
+		for key, value := range addon.ConfigurationValues {
+			configurationValues[key] = value
+		}
+		// OVERWRITE would reset the CNI settings made by the module, so they are part of the add-on configuration
+		if addon.Name == "vpc-cni" {
+			env := vpcCniEnv(EksConfig, customNetworking)
+			if configuredEnv, ok := configurationValues["env"].(map[string]interface{}); ok {
+				for key, value := range configuredEnv {
+					env[key] = value
+				}
+			}
+			if len(env) > 0 {
+				configurationValues["env"] = env
+			}
+		}
+		var configurationValuesJson pulumi.StringPtrInput
+		if len(configurationValues) > 0 {
+			valuesJson, err := json.Marshal(configurationValues)
+			if err != nil {
+				return nil, err
+			}
+			configurationValuesJson = pulumi.String(string(valuesJson))
+		}
+
+		var serviceAccountRoleArn pulumi.StringPtrInput
+		if addon.Irsa {
+			serviceAccount := addon.ServiceAccount
+			policyArns := addon.IrsaPolicyArns
+			if serviceAccount == "" {
+				serviceAccount = addonIrsaDefaults[addon.Name].serviceAccount
+			}
+			if len(policyArns) == 0 {
+				policyArns = addonIrsaDefaults[addon.Name].policyArns
+			}
+			irsaPolicyArns := pulumi.ToStringArray(policyArns)
+			// aws-node no longer uses the node role, so it needs the IPv6 policy too in IPv6 clusters
+			if addon.Name == "vpc-cni" && cniIpv6Policy != nil {
+				irsaPolicyArns = append(irsaPolicyArns, cniIpv6Policy.Arn)
+			}
+			namespaceAndName := strings.Split(serviceAccount, "/")
+			irsaRole, err := NewIrsaRole(ctx, EksConfig.Name+"-"+addon.Name+"-irsa-role", eksCluster.EksCluster, namespaceAndName[0], namespaceAndName[1], irsaPolicyArns, CommonTags)
+			if err != nil {
+				return nil, err
+			}
+			serviceAccountRoleArn = irsaRole.Arn
+		}
+
+		eksAddon, err := awseks.NewAddon(ctx, EksConfig.Name+"-addon-"+addon.Name, &awseks.AddonArgs{
+			ClusterName:           eksCluster.EksCluster.Name(),
+			AddonName:             pulumi.String(addon.Name),
+			AddonVersion:          pulumi.String(addonVersions[addon.Name]),
+			ResolveConflicts:      pulumi.String(resolveConflicts),
+			ServiceAccountRoleArn: serviceAccountRoleArn,
+			ConfigurationValues:   configurationValuesJson,
+			Tags:                  pulumi.StringMap(CommonTags),
+		}, pulumi.DependsOn(dependsOn))
+		if err != nil {
+			return nil, err
+		}
+		addons[addon.Name] = eksAddon
+	}
+	return addons, nil
+}
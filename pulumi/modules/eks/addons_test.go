@@ -0,0 +1,84 @@
+package eks
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareAddonVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{a: "v1.15.1-eksbuild.1", b: "v1.15.1-eksbuild.1", want: 0},
+		{a: "v1.15.1-eksbuild.2", b: "v1.15.1-eksbuild.1", want: 1},
+		{a: "v1.9.3-eksbuild.1", b: "v1.10.1-eksbuild.1", want: -1},
+		{a: "v1.16.0-eksbuild.1", b: "v1.15.4-eksbuild.3", want: 1},
+		{a: "1.15.1-eksbuild.1", b: "v1.15.1-eksbuild.1", want: 0},
+		{a: "v1.15.1", b: "v1.15.1-eksbuild.1", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := compareAddonVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareAddonVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddonVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "most recent first",
+			output: `{"addons":[{"addonName":"vpc-cni","addonVersions":[{"addonVersion":"v1.9.3-eksbuild.1"},{"addonVersion":"v1.15.1-eksbuild.1"},{"addonVersion":"v1.10.1-eksbuild.1"}]}]}`,
+			want:   []string{"v1.15.1-eksbuild.1", "v1.10.1-eksbuild.1", "v1.9.3-eksbuild.1"},
+		},
+		{
+			name:   "no compatible version",
+			output: `{"addons":[]}`,
+			want:   []string{},
+		},
+		{
+			name:    "invalid output",
+			output:  `An error occurred`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAddonVersions([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAddonVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAddonVersions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCompatibleAddonVersion(t *testing.T) {
+	compatibleVersions := []string{"v1.15.1-eksbuild.1", "v1.10.1-eksbuild.1"}
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{version: "v1.15.1-eksbuild.1", want: true},
+		{version: "v1.10.1-eksbuild.1", want: true},
+		{version: "v1.12.0-eksbuild.1", want: false},
+		{version: "v1.15.1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := isCompatibleAddonVersion(tt.version, compatibleVersions); got != tt.want {
+				t.Errorf("isCompatibleAddonVersion(%s) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
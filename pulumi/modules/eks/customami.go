@@ -0,0 +1,68 @@
+package eks
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// generateLinuxCustomAmiUserData renders the user data of a managed node group running its own AMI ("amiId" key).
+// EKS doesn't add its bootstrap to custom AMIs, so the node is joined to the cluster here, following the
+// "amiType" key of the node group to know which OS family the AMI was built from. The service CIDR is the IPv6 one
+// in IPv6 clusters.
+func generateLinuxCustomAmiUserData(amiType string, clusterName string, endpoint string, certificateAuthority string, ipFamily string, serviceCidr string, nodeGroupName string, maxPods int) string {
+	nodeLabels := "eks.amazonaws.com/nodegroup=" + nodeGroupName
+	var userData string
+	switch {
+	case strings.HasPrefix(amiType, "BOTTLEROCKET"):
+		userData = fmt.Sprintf("[settings.kubernetes]\ncluster-name = %q\napi-server = %q\ncluster-certificate = %q\n", clusterName, endpoint, certificateAuthority)
+		if maxPods > 0 {
+			userData += fmt.Sprintf("max-pods = %d\n", maxPods)
+		}
+		userData += fmt.Sprintf("\n[settings.kubernetes.node-labels]\n%q = %q\n", "eks.amazonaws.com/nodegroup", nodeGroupName)
+
+	case strings.HasPrefix(amiType, "AL2023"):
+		kubeletConfig := ""
+		if maxPods > 0 {
+			kubeletConfig = fmt.Sprintf("\n    config:\n      maxPods: %d", maxPods)
+		}
+		userData = fmt.Sprintf(`MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="//"
+
+--//
+Content-Type: application/node.eks.aws
+
+---
+apiVersion: node.eks.aws/v1alpha1
+kind: NodeConfig
+spec:
+  cluster:
+    name: %s
+    apiServerEndpoint: %s
+    certificateAuthority: %s
+    cidr: %s
+  kubelet:
+    flags:
+      - --node-labels=%s%s
+
+--//--
+`, clusterName, endpoint, certificateAuthority, serviceCidr, nodeLabels, kubeletConfig)
+
+	default:
+		kubeletExtraArgs := "--node-labels=" + nodeLabels
+		bootstrapArgs := " --service-ipv4-cidr " + serviceCidr
+		if ipFamily == "ipv6" {
+			bootstrapArgs = " --ip-family ipv6 --service-ipv6-cidr " + serviceCidr
+		}
+		if maxPods > 0 {
+			kubeletExtraArgs += fmt.Sprintf(" --max-pods=%d", maxPods)
+			bootstrapArgs += " --use-max-pods false"
+		}
+		userData = fmt.Sprintf(`#!/bin/bash
+set -ex
+/etc/eks/bootstrap.sh %s --apiserver-endpoint %s --b64-cluster-ca %s%s --kubelet-extra-args '%s'
+`, clusterName, endpoint, certificateAuthority, bootstrapArgs, kubeletExtraArgs)
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(userData))
+}
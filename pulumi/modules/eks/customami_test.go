@@ -0,0 +1,111 @@
+package eks
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestGenerateLinuxCustomAmiUserData(t *testing.T) {
+	tests := []struct {
+		name        string
+		amiType     string
+		ipFamily    string
+		serviceCidr string
+		maxPods     int
+		contains    []string
+		excludes    []string
+	}{
+		{
+			name:        "AL2 IPv4",
+			amiType:     "AL2_x86_64",
+			ipFamily:    "ipv4",
+			serviceCidr: "172.20.0.0/16",
+			contains: []string{
+				"/etc/eks/bootstrap.sh staging --apiserver-endpoint https://example.eks.amazonaws.com --b64-cluster-ca Q0E= --service-ipv4-cidr 172.20.0.0/16 --kubelet-extra-args '--node-labels=eks.amazonaws.com/nodegroup=workers'\n",
+			},
+			excludes: []string{"--use-max-pods", "--max-pods"},
+		},
+		{
+			name:        "AL2 IPv6",
+			amiType:     "AL2_ARM_64",
+			ipFamily:    "ipv6",
+			serviceCidr: "fd00:1234::/108",
+			contains: []string{
+				" --b64-cluster-ca Q0E= --ip-family ipv6 --service-ipv6-cidr fd00:1234::/108 --kubelet-extra-args ",
+			},
+			excludes: []string{"--service-ipv4-cidr"},
+		},
+		{
+			name:        "AL2 max pods",
+			amiType:     "AL2_x86_64",
+			ipFamily:    "ipv4",
+			serviceCidr: "172.20.0.0/16",
+			maxPods:     110,
+			contains: []string{
+				" --service-ipv4-cidr 172.20.0.0/16 --use-max-pods false --kubelet-extra-args '--node-labels=eks.amazonaws.com/nodegroup=workers --max-pods=110'\n",
+			},
+			excludes: []string{"sed "},
+		},
+		{
+			name:        "AL2023",
+			amiType:     "AL2023_x86_64_STANDARD",
+			ipFamily:    "ipv4",
+			serviceCidr: "172.20.0.0/16",
+			contains: []string{
+				"kind: NodeConfig\n",
+				"    name: staging\n    apiServerEndpoint: https://example.eks.amazonaws.com\n    certificateAuthority: Q0E=\n    cidr: 172.20.0.0/16\n",
+				"      - --node-labels=eks.amazonaws.com/nodegroup=workers\n",
+			},
+			excludes: []string{"maxPods", "bootstrap.sh"},
+		},
+		{
+			name:        "AL2023 max pods",
+			amiType:     "AL2023_ARM_64_STANDARD",
+			ipFamily:    "ipv6",
+			serviceCidr: "fd00:1234::/108",
+			maxPods:     58,
+			contains: []string{
+				"    cidr: fd00:1234::/108\n",
+				"      - --node-labels=eks.amazonaws.com/nodegroup=workers\n    config:\n      maxPods: 58\n",
+			},
+		},
+		{
+			name:     "Bottlerocket",
+			amiType:  "BOTTLEROCKET_x86_64",
+			ipFamily: "ipv4",
+			contains: []string{
+				"[settings.kubernetes]\ncluster-name = \"staging\"\napi-server = \"https://example.eks.amazonaws.com\"\ncluster-certificate = \"Q0E=\"\n\n",
+				"[settings.kubernetes.node-labels]\n\"eks.amazonaws.com/nodegroup\" = \"workers\"\n",
+			},
+			excludes: []string{"max-pods"},
+		},
+		{
+			name:     "Bottlerocket max pods",
+			amiType:  "BOTTLEROCKET_ARM_64",
+			ipFamily: "ipv4",
+			maxPods:  34,
+			contains: []string{"cluster-certificate = \"Q0E=\"\nmax-pods = 34\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := generateLinuxCustomAmiUserData(tt.amiType, "staging", "https://example.eks.amazonaws.com", "Q0E=", tt.ipFamily, tt.serviceCidr, "workers", tt.maxPods)
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			userData := string(decoded)
+			for _, want := range tt.contains {
+				if !strings.Contains(userData, want) {
+					t.Errorf("user data doesn't contain %q:\n%s", want, userData)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(userData, unwanted) {
+					t.Errorf("user data contains %q:\n%s", unwanted, userData)
+				}
+			}
+		})
+	}
+}
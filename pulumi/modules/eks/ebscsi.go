@@ -0,0 +1,94 @@
+package eks
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	storagev1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/storage/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type EbsCsiConfig struct {
+	Enabled bool
+	// Version of the aws-ebs-csi-driver add-on, "latest" by default
+	Version      string
+	StorageClass StorageClassConfig
+}
+
+type StorageClassConfig struct {
+	// "runner-cache" by default
+	Name string
+	// Make it the default StorageClass of the cluster
+	Default bool
+	// Parameters of the EBS CSI driver (type, iops, throughput, encrypted, kmsKeyId...), over type gp3 and encrypted true
+	Parameters map[string]string
+	// Delete (default) or Retain
+	ReclaimPolicy string
+	// WaitForFirstConsumer (default), so the volume is created in the AZ of the pod, or Immediate
+	VolumeBindingMode string
+}
+
+// addEbsCsiAddon adds the aws-ebs-csi-driver add-on, with its IRSA role, to the managed add-ons
+// when the EBS CSI driver is enabled. An add-on already listed in the config is kept, with IRSA enabled.
+func addEbsCsiAddon(EksConfig *EksConfig) {
+	if !EksConfig.EbsCsi.Enabled {
+		return
+	}
+	for index := range EksConfig.Addons {
+		if EksConfig.Addons[index].Name == "aws-ebs-csi-driver" {
+			EksConfig.Addons[index].Irsa = true
+			return
+		}
+	}
+	EksConfig.Addons = append(EksConfig.Addons, AddonConfig{
+		Name:    "aws-ebs-csi-driver",
+		Version: EksConfig.EbsCsi.Version,
+		Irsa:    true,
+	})
+}
+
+// createEbsStorageClass creates the gp3 StorageClass served by the EBS CSI driver, meant for the work directories
+// and caches of the runners
+func createEbsStorageClass(ctx *pulumi.Context, EksConfig *EksConfig, k8sProvider *kubernetes.Provider, dependsOn []pulumi.Resource) error {
+	if !EksConfig.EbsCsi.Enabled {
+		return nil
+	}
+	storageClassConfig := EksConfig.EbsCsi.StorageClass
+
+	name := storageClassConfig.Name
+	if name == "" {
+		name = "runner-cache"
+	}
+	reclaimPolicy := storageClassConfig.ReclaimPolicy
+	if reclaimPolicy == "" {
+		reclaimPolicy = "Delete"
+	}
+	volumeBindingMode := storageClassConfig.VolumeBindingMode
+	if volumeBindingMode == "" {
+		volumeBindingMode = "WaitForFirstConsumer"
+	}
+
+	parameters := pulumi.StringMap{
+		"type":      pulumi.String("gp3"),
+		"encrypted": pulumi.String("true"),
+	}
+	for key, value := range storageClassConfig.Parameters {
+		parameters[key] = pulumi.String(value)
+	}
+
+	_, err := storagev1.NewStorageClass(ctx, EksConfig.Name+"-storage-class-"+name, &storagev1.StorageClassArgs{
+		Metadata: &metav1.ObjectMetaArgs{
+			Name: pulumi.String(name),
+			Annotations: pulumi.StringMap{
+				"storageclass.kubernetes.io/is-default-class": pulumi.String(fmt.Sprintf("%t", storageClassConfig.Default)),
+			},
+		},
+		Provisioner:          pulumi.String("ebs.csi.aws.com"),
+		Parameters:           parameters,
+		ReclaimPolicy:        pulumi.String(reclaimPolicy),
+		VolumeBindingMode:    pulumi.String(volumeBindingMode),
+		AllowVolumeExpansion: pulumi.Bool(true),
+	}, pulumi.Provider(k8sProvider), pulumi.DependsOn(dependsOn))
+	return err
+}
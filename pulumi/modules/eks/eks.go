@@ -28,9 +28,36 @@ func errorHandler(err error) {
 	}
 }
 
+// addNameToCommonTags returns a copy of the common tags with the Name tag
+func addNameToCommonTags(name string, CommonTags pulumi.StringMap) pulumi.StringMap {
+	tags := pulumi.StringMap{"Name": pulumi.String(name)}
+	for key, value := range CommonTags {
+		tags[key] = value
+	}
+	return tags
+}
+
 type EksConfig struct {
-	Name              string
-	Version           string
+	Name    string
+	Version string
+	// "ipv4" (default) or "ipv6". IPv6 clusters need a dual-stack VPC and don't support Windows nodes
+	IpFamily string
+	// Assign /28 prefixes instead of single IPs to the ENIs of the Linux nodes, and raise their max-pods accordingly
+	EnablePrefixDelegation bool
+	// Access to the API server endpoint, public from anywhere by default
+	Endpoint EndpointConfig
+	// Envelope encryption of the Kubernetes secrets with a KMS key
+	SecretsEncryption SecretsEncryptionConfig
+	// Control plane log types sent to CloudWatch, and the retention of their log group
+	ControlPlaneLogs ControlPlaneLogsConfig
+	// Managed add-ons, the ones not listed stay as installed at cluster creation
+	Addons []AddonConfig
+	// EBS CSI driver and the StorageClass of the runner caches
+	EbsCsi EbsCsiConfig
+	// Drain of the nodes before their termination (scale in, spot interruption)
+	TerminationHandler TerminationHandlerConfig
+	// Scheduled actions of the node groups, keyed by node group name
+	ScheduledScaling  map[string][]ScheduledActionConfig
 	Tags              map[string]string
 	LinuxNodegroups   map[string]map[string]string
 	WindowsNodegroups map[string]map[string]string
@@ -40,22 +67,76 @@ type EksOutput struct {
 	EksClusterOutput    awseks.ClusterOutput
 	LinuxNodeGroupRoles map[string]*iam.Role
 	LinuxNodeGroups     []*awseks.NodeGroup
+	Addons              map[string]*awseks.Addon
 	WindowsNodeGroups   []*autoscaling.Group
+	// Roles of the Windows node groups, keyed like WindowsNodegroups
+	WindowsNodeGroupRoles map[string]*iam.Role
+	// IRSA role of the cluster autoscaler service account
+	AutoScalerRole *iam.Role
 }
 
 type TemplateInput struct {
 	ClusterName        string
 	BootstrapArguments string
 	AwsRegion          string
+	PrePullScript      string
+	// Launch lifecycle hook of the group, set with a warm pool
+	LaunchHook string
 }
 
-func CreateEKSCluster(ctx *pulumi.Context, vpc *ec2.Vpc, subnets []*ec2.Subnet) (EksOutput, error) {
+func CreateEKSCluster(ctx *pulumi.Context, vpc *ec2.Vpc, subnets []*ec2.Subnet, podSubnets []*ec2.Subnet, nodeSubnets map[string]NodeSubnets) (EksOutput, error) {
 
 	// Get the EKS config from context
 	EksConfig := &EksConfig{}
 	conf := config.New(ctx, "")
 	conf.RequireObject("Eks", &EksConfig)
 
+	if EksConfig.IpFamily == "ipv6" && len(EksConfig.WindowsNodegroups) > 0 {
+		return EksOutput{}, fmt.Errorf("eks %s: Windows node groups are not supported in IPv6 clusters", EksConfig.Name)
+	}
+	if err := validateEndpoint(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	if err := validateControlPlaneLogs(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	if err := validatePrePull(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	if err := validateWarmPools(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	if err := validateTerminationHandler(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	if err := validateScheduledScaling(EksConfig); err != nil {
+		return EksOutput{}, err
+	}
+	// The node groups default to the subnets of the control plane
+	if nodeSubnets == nil {
+		nodeSubnets = map[string]NodeSubnets{}
+	}
+	if _, ok := nodeSubnets["private"]; !ok {
+		nodeSubnets["private"] = NodeSubnets{Subnets: subnets}
+	}
+	if err := validateNodeGroupSubnets(EksConfig, nodeSubnets); err != nil {
+		return EksOutput{}, err
+	}
+	addEbsCsiAddon(EksConfig)
+	addonVersions, err := resolveAddonVersions(ctx, EksConfig)
+	if err != nil {
+		return EksOutput{}, err
+	}
+	// Upgrades are applied in order: control plane, add-ons, Linux node groups, then Windows instance refresh
+	runningVersion, err := planUpgrade(ctx, EksConfig)
+	if err != nil {
+		return EksOutput{}, err
+	}
+	upgrade := runningVersion != ""
+	if upgrade {
+		logUpgradePlan(ctx, EksConfig, runningVersion, addonVersions)
+	}
+
 	// Create a pulumiStringMap for the Tags
 	CommonTags := pulumi.StringMap{}
 	for index, tag := range EksConfig.Tags {
@@ -97,42 +178,113 @@ func CreateEKSCluster(ctx *pulumi.Context, vpc *ec2.Vpc, subnets []*ec2.Subnet)
 		errorHandler(err)
 	}
 
+	secretsKeyArn, secretsKeyDependencies, err := secretsEncryptionKey(ctx, EksConfig, CommonTags, eksRole)
+	errorHandler(err)
+
+	enabledClusterLogTypes, logGroupDependencies, err := createControlPlaneLogGroup(ctx, EksConfig, CommonTags)
+	errorHandler(err)
+
 	// Create the roles for all linux nodegroups, so we can add them to the aws-auth automatically.
 	// Not possible to use the same approach for Windows node groups since we have to also add the role to eks:kube-proxy-windows group
 	// So that step will still be done by hand as described on the README.md
 	linuxNodeGroupRoleArray := iam.RoleArray{}
-	EksOutput.LinuxNodeGroupRoles, linuxNodeGroupRoleArray = createLinuxNodeGroupRoles(ctx, EksConfig, CommonTags)
+	// AmazonEKS_CNI_Policy only covers IPv4, the CNI needs its own policy to assign IPv6 addresses, whether it runs
+	// with the node roles or with the IRSA role of the vpc-cni add-on
+	var cniIpv6Policy *iam.Policy
+	if ipFamily(EksConfig) == "ipv6" {
+		cniIpv6Policy = createCniIpv6Policy(ctx, EksConfig)
+	}
+	EksOutput.LinuxNodeGroupRoles, linuxNodeGroupRoleArray = createLinuxNodeGroupRoles(ctx, EksConfig, CommonTags, cniIpv6Policy)
+
+	// When pod subnets are given, the VPC CNI custom networking is enabled so pods take their IPs
+	// from the pod subnet of their AZ (through the ENIConfig named after the AZ) instead of the node subnet
+	customNetworking := len(podSubnets) > 0
+	// When the vpc-cni add-on is managed, the CNI settings go through its configuration values instead
+	managedVpcCni := hasAddon(EksConfig, "vpc-cni")
+	var vpcCniOptions *eks.VpcCniOptionsArgs
+	if !managedVpcCni && (customNetworking || EksConfig.EnablePrefixDelegation) {
+		vpcCniOptions = &eks.VpcCniOptionsArgs{}
+	}
+	if vpcCniOptions != nil && customNetworking {
+		vpcCniOptions.CustomNetworkConfig = pulumi.Bool(true)
+		vpcCniOptions.EniConfigLabelDef = pulumi.String("topology.kubernetes.io/zone")
+	}
+	// Keep one spare prefix per node, so pods don't wait for the CNI to attach a new one
+	if vpcCniOptions != nil && EksConfig.EnablePrefixDelegation {
+		vpcCniOptions.EnablePrefixDelegation = pulumi.Bool(true)
+		vpcCniOptions.WarmPrefixTarget = pulumi.Int(1)
+	}
 
 	// Create EKS Cluster
 	eksCluster, err := eks.NewCluster(ctx, "eks-cluster", &eks.ClusterArgs{
-		CreateOidcProvider: pulumi.Bool(true),
-		Name:               pulumi.String(EksConfig.Name),
-		PublicAccessCidrs: pulumi.StringArray{
-			pulumi.String("0.0.0.0/0"),
-		},
-		ServiceRole:          eksRole,
-		SkipDefaultNodeGroup: pulumi.Bool(true),
-		SubnetIds:            getSubnetIds(subnets),
-		Tags:                 pulumi.StringMap(CommonTags),
-		Version:              pulumi.String(EksConfig.Version),
-		VpcId:                vpc.ID(),
-		InstanceRoles:        linuxNodeGroupRoleArray,
-	})
+		CreateOidcProvider:     pulumi.Bool(true),
+		Name:                   pulumi.String(EksConfig.Name),
+		EndpointPrivateAccess:  pulumi.Bool(endpointAccess(EksConfig) != "public"),
+		EndpointPublicAccess:   pulumi.Bool(endpointAccess(EksConfig) != "private"),
+		PublicAccessCidrs:      publicAccessCidrs(EksConfig),
+		EncryptionConfigKeyArn: secretsKeyArn,
+		EnabledClusterLogTypes: enabledClusterLogTypes,
+		ServiceRole:            eksRole,
+		SkipDefaultNodeGroup:   pulumi.Bool(true),
+		SubnetIds:              getSubnetIds(subnets),
+		Tags:                   pulumi.StringMap(CommonTags),
+		Version:                pulumi.String(EksConfig.Version),
+		VpcId:                  vpc.ID(),
+		InstanceRoles:          linuxNodeGroupRoleArray,
+		IpFamily:               pulumi.String(ipFamily(EksConfig)),
+		VpcCniOptions:          vpcCniOptions,
+		UseDefaultVpcCni:       pulumi.Bool(managedVpcCni),
+	}, pulumi.DependsOn(append(secretsKeyDependencies, logGroupDependencies...)))
 	errorHandler(err)
 
 	EksOutput.EksClusterOutput = eksCluster.EksCluster
 
+	err = createPrivateEndpointAccess(ctx, EksConfig, CommonTags, vpc, subnets, eksCluster)
+	errorHandler(err)
+
+	k8sProvider := newKubernetesProvider(ctx, eksCluster)
+
+	// The ENIConfigs have to exist before the nodes join, otherwise the nodes need to be recycled
+	eniConfigs := createEniConfigs(ctx, k8sProvider, eksCluster, podSubnets)
+
+	// Add-ons the nodes need when they join (CNI, kube-proxy), or all of them during an upgrade
+	EksOutput.Addons, err = createAddons(ctx, EksConfig, CommonTags, eksCluster, addonVersions, customNetworking, cniIpv6Policy, upgrade, true, nil)
+	errorHandler(err)
+	nodeGroupDependencies := eniConfigs
+	for _, addon := range EksOutput.Addons {
+		nodeGroupDependencies = append(nodeGroupDependencies, addon)
+	}
+
 	////////////////////////////////////////
 	// Linux Node Groups////////////////////
 	////////////////////////////////////////
-	EksOutput.LinuxNodeGroups = createLinuxNodeGroups(ctx, EksConfig, CommonTags, subnets, eksCluster, EksOutput.LinuxNodeGroupRoles)
+	EksOutput.LinuxNodeGroups = createLinuxNodeGroups(ctx, EksConfig, CommonTags, nodeSubnets, eksCluster, EksOutput.LinuxNodeGroupRoles, customNetworking, nodeGroupDependencies)
+
+	// Add-ons that need running nodes to become active (coredns, aws-ebs-csi-driver)
+	addonDependencies := []pulumi.Resource{}
+	for _, nodeGroup := range EksOutput.LinuxNodeGroups {
+		addonDependencies = append(addonDependencies, nodeGroup)
+	}
+	nodeAddons, err := createAddons(ctx, EksConfig, CommonTags, eksCluster, addonVersions, customNetworking, cniIpv6Policy, upgrade, false, addonDependencies)
+	errorHandler(err)
+	for name, addon := range nodeAddons {
+		EksOutput.Addons[name] = addon
+	}
+
+	if addon, ok := EksOutput.Addons["aws-ebs-csi-driver"]; ok {
+		err = createEbsStorageClass(ctx, EksConfig, k8sProvider, []pulumi.Resource{addon})
+		errorHandler(err)
+	}
 
 	/////////////////////////////////////////
 	// Windows Node Groups///////////////////
 	/////////////////////////////////////////
-	EksOutput.WindowsNodeGroups = createWindowsNodeGroups(ctx, EksConfig, CommonTags, vpc, subnets, eksCluster, EksOutput)
+	EksOutput.WindowsNodeGroups = createWindowsNodeGroups(ctx, EksConfig, CommonTags, vpc, subnets, nodeSubnets, eksCluster, EksOutput)
+
+	err = createTerminationHandler(ctx, EksConfig, CommonTags, eksCluster, k8sProvider, EksOutput.LinuxNodeGroups, EksOutput.WindowsNodeGroups)
+	errorHandler(err)
 
-	err = createAutoScalerIamResources(ctx, eksCluster)
+	EksOutput.AutoScalerRole, err = createAutoScalerIamResources(ctx, eksCluster)
 	errorHandler(err)
 
 	return *EksOutput, nil
@@ -146,26 +298,60 @@ func getSubnetIds(subnets []*ec2.Subnet) pulumi.StringArray {
 	return utilities.IdOutputArrayToStringOutputArray(subnetIds)
 }
 
-func generatePowershellTemplate(clusterName string, region string) string {
+func generatePowershellTemplate(clusterName string, region string, maxPods int, prePullImages []string, launchHook string) string {
 	tplstring := `<powershell>
 [string]$EKSBinDir = "$env:ProgramFiles\Amazon\EKS"
 [string]$EKSBootstrapScriptName = 'Start-EKSBootstrap.ps1'
 [string]$EKSBootstrapScriptFile = "$EKSBinDir\$EKSBootstrapScriptName"
 [string]$cfn_signal = "$env:ProgramFiles\Amazon\cfn-bootstrap\cfn-signal.exe"
-& $EKSBootstrapScriptFile -EKSClusterName {{.ClusterName}} {{.BootstrapArguments}} 3>&1 4>&1 5>&1 6>&1
+{{- if .LaunchHook}}
+[string]$Token = Invoke-RestMethod -Method Put -Uri http://169.254.169.254/latest/api/token -Headers @{"X-aws-ec2-metadata-token-ttl-seconds" = "300"}
+[string]$InstanceId = Invoke-RestMethod -Uri http://169.254.169.254/latest/meta-data/instance-id -Headers @{"X-aws-ec2-metadata-token" = $Token}
+[string]$TargetState = Invoke-RestMethod -Uri http://169.254.169.254/latest/meta-data/autoscaling/target-lifecycle-state -Headers @{"X-aws-ec2-metadata-token" = $Token}
+# The launch template is shared by the groups of a node group placed per AZ
+function Complete-LaunchHook([string]$Result) {
+  try {
+    $AutoScalingGroupName = (Get-ASAutoScalingInstance -InstanceId $InstanceId -Region {{.AwsRegion}}).AutoScalingGroupName
+    Complete-ASLifecycleAction -AutoScalingGroupName $AutoScalingGroupName -LifecycleHookName {{.LaunchHook}} -InstanceId $InstanceId -LifecycleActionResult $Result -Region {{.AwsRegion}}
+  } catch { Write-Output "No launch hook to complete: $_" }
+}
+# Instances going to the warm pool get ready, but only join the cluster when they enter service
+if ($TargetState -like "Warmed:*") {
+{{.PrePullScript}}Complete-LaunchHook "CONTINUE"
+exit 0
+}
+{{- end}}
+{{.PrePullScript}}& $EKSBootstrapScriptFile -EKSClusterName {{.ClusterName}} {{.BootstrapArguments}} 3>&1 4>&1 5>&1 6>&1
 $LastError = if ($?) { 0 } else { $Error[0].Exception.HResult }
+{{- if .LaunchHook}}
+Complete-LaunchHook $(if ($LastError -eq 0) { "CONTINUE" } else { "ABANDON" })
+{{- end}}
 & $cfn_signal --exit-code=$LastError ` + "`" + `
   --resource="NodeGroup" ` + "`" + `
   --region={{.AwsRegion}}
-</powershell>`
+</powershell>
+{{- if .LaunchHook}}
+<persist>true</persist>
+{{- end}}`
 
 	tpl, err := template.New("Template").Parse(tplstring)
 	errorHandler(err)
 
+	bootstrapArguments := "-ContainerRuntime containerd"
+	if maxPods > 0 {
+		bootstrapArguments += fmt.Sprintf(" -KubeletExtraArgs '--max-pods=%d'", maxPods)
+	}
+
 	tplInput := TemplateInput{
 		ClusterName:        clusterName,
-		BootstrapArguments: "-ContainerRuntime containerd",
+		BootstrapArguments: bootstrapArguments,
 		AwsRegion:          region,
+		// The user data runs on every boot with a warm pool, to bootstrap the node when it leaves the pool
+		LaunchHook: launchHook,
+	}
+	// Images pulled before the node joins the cluster, so the first runner pods don't wait for them
+	if len(prePullImages) > 0 {
+		tplInput.PrePullScript = generatePullImagesScript(prePullImages)
 	}
 	var tplBytes bytes.Buffer
 	err = tpl.Execute(&tplBytes, tplInput)
@@ -174,7 +360,7 @@ $LastError = if ($?) { 0 } else { $Error[0].Exception.HResult }
 	return base64.StdEncoding.EncodeToString([]byte(tplBytes.Bytes()))
 }
 
-func createAutoScalerIamResources(ctx *pulumi.Context, eksCluster *eks.Cluster) error {
+func createAutoScalerIamResources(ctx *pulumi.Context, eksCluster *eks.Cluster) (*iam.Role, error) {
 	autoScalingPolicyJson, err := json.Marshal(map[string]interface{}{
 		"Version": "2012-10-17",
 		"Statement": []map[string]interface{}{
@@ -282,12 +468,13 @@ func createAutoScalerIamResources(ctx *pulumi.Context, eksCluster *eks.Cluster)
 	errorHandler(err)
 
 	ctx.Export("autoScalerRoleArn", autoScalerRole.Arn)
-	return nil
+	return autoScalerRole, nil
 }
 
-func createLinuxNodeGroupRoles(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap) (map[string]*iam.Role, iam.RoleArray) {
+func createLinuxNodeGroupRoles(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, cniIpv6Policy *iam.Policy) (map[string]*iam.Role, iam.RoleArray) {
 	linuxNodeGroupRoles := map[string]*iam.Role{}
 	arrayLinuxNodeGroupRoles := iam.RoleArray{}
+
 	for key := range EksConfig.LinuxNodegroups {
 
 		// Assume Role for the node group
@@ -327,13 +514,66 @@ func createLinuxNodeGroupRoles(ctx *pulumi.Context, EksConfig *EksConfig, Common
 			errorHandler(err)
 		}
 		errorHandler(err)
+		if cniIpv6Policy != nil {
+			_, err := iam.NewRolePolicyAttachment(ctx, EksConfig.LinuxNodegroups[key]["name"]+"-role-pa-cni-ipv6", &iam.RolePolicyAttachmentArgs{
+				Role:      nodeGroupRole.Name,
+				PolicyArn: cniIpv6Policy.Arn,
+			})
+			errorHandler(err)
+		}
 		linuxNodeGroupRoles[key] = nodeGroupRole
 		arrayLinuxNodeGroupRoles = append(arrayLinuxNodeGroupRoles, nodeGroupRole)
 	}
 	return linuxNodeGroupRoles, arrayLinuxNodeGroupRoles
 }
 
-func createLinuxNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, subnets []*ec2.Subnet, eksCluster *eks.Cluster, linuxNodeGroupRoles map[string]*iam.Role) []*awseks.NodeGroup {
+// ipFamily returns the configured IP family of the cluster, defaulting to IPv4
+func ipFamily(EksConfig *EksConfig) string {
+	if EksConfig.IpFamily == "" {
+		return "ipv4"
+	}
+	return EksConfig.IpFamily
+}
+
+// createCniIpv6Policy creates the IAM policy the VPC CNI needs in IPv6 clusters, as documented in
+// https://docs.aws.amazon.com/eks/latest/userguide/cni-iam-role.html#cni-iam-role-create-ipv6-policy
+func createCniIpv6Policy(ctx *pulumi.Context, EksConfig *EksConfig) *iam.Policy {
+	cniIpv6PolicyJson, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			map[string]interface{}{
+				"Action": []string{
+					"ec2:AssignIpv6Addresses",
+					"ec2:DescribeInstances",
+					"ec2:DescribeTags",
+					"ec2:DescribeNetworkInterfaces",
+					"ec2:DescribeInstanceTypes",
+				},
+				"Effect":   "Allow",
+				"Resource": "*",
+			},
+			map[string]interface{}{
+				"Action": []string{
+					"ec2:CreateTags",
+				},
+				"Effect":   "Allow",
+				"Resource": "arn:aws:ec2:*:*:network-interface/*",
+			},
+		},
+	})
+	errorHandler(err)
+
+	cniIpv6Policy, err := iam.NewPolicy(ctx, "AmazonEKS_CNI_IPv6_Policy", &iam.PolicyArgs{
+		Name:        pulumi.String(EksConfig.Name + "-AmazonEKS_CNI_IPv6_Policy"),
+		Description: pulumi.String("Policy for the VPC CNI in IPv6 clusters"),
+		Path:        pulumi.String("/"),
+		Policy:      pulumi.String(cniIpv6PolicyJson),
+	})
+	errorHandler(err)
+	return cniIpv6Policy
+}
+
+func createLinuxNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, nodeSubnets map[string]NodeSubnets, eksCluster *eks.Cluster, linuxNodeGroupRoles map[string]*iam.Role, customNetworking bool, dependsOn []pulumi.Resource) []*awseks.NodeGroup {
 
 	nodeGroups := []*awseks.NodeGroup{}
 	for key := range EksConfig.LinuxNodegroups {
@@ -354,36 +594,127 @@ func createLinuxNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags
 
 		CommonTags["k8s.io/cluster-autoscaler/enabled"] = pulumi.String("true")
 
-		// Creating the node group
-		nodeGroup, err := awseks.NewNodeGroup(ctx, EksConfig.LinuxNodegroups[key]["name"], &awseks.NodeGroupArgs{
-			ClusterName:   clusterName,
-			NodeGroupName: pulumi.String(EksConfig.LinuxNodegroups[key]["name"]),
-			NodeRoleArn:   pulumi.StringInput(linuxNodeGroupRoles[key].Arn),
-			SubnetIds:     getSubnetIds(subnets),
-			InstanceTypes: pulumi.StringArray{pulumi.String(EksConfig.LinuxNodegroups[key]["instanceType"])},
-			AmiType:       pulumi.String(EksConfig.LinuxNodegroups[key]["amiType"]),
-			DiskSize:      pulumi.Int(diskSize),
-			ScalingConfig: &awseks.NodeGroupScalingConfigArgs{
-				DesiredSize: pulumi.Int(desiredSize),
-				MaxSize:     pulumi.Int(maxSize),
-				MinSize:     pulumi.Int(minSize),
-			},
-			RemoteAccess: &awseks.NodeGroupRemoteAccessArgs{
-				Ec2SshKey: pulumi.String(EksConfig.LinuxNodegroups[key]["sshKey"]),
-			},
-			Tags: pulumi.StringMap(CommonTags),
-		})
+		maxPods, err := nodeGroupMaxPods(ctx, EksConfig, EksConfig.LinuxNodegroups[key], false, customNetworking)
+		errorHandler(err)
+
+		// An AMI built by the imagebuilder module, rolled out deliberately by setting its ID.
+		// AL2 node groups with max-pods run the EKS optimized AMI of the version as a custom AMI, to pass
+		// max-pods to bootstrap.sh: EKS runs the bootstrap itself, without extra arguments, when no AMI is given
+		amiId := EksConfig.LinuxNodegroups[key]["amiId"]
+		if amiId == "" && maxPods > 0 && isAl2AmiType(EksConfig.LinuxNodegroups[key]["amiType"]) {
+			amiId, err = eksOptimizedAl2AmiId(ctx, EksConfig.LinuxNodegroups[key]["amiType"], EksConfig.Version)
+			errorHandler(err)
+		}
+
+		// One node group per placement, suffixed with its AZ when the node group has one group per AZ
+		placements, err := nodeGroupPlacements(EksConfig.LinuxNodegroups[key], nodeSubnets)
 		errorHandler(err)
-		nodeGroups = append(nodeGroups, nodeGroup)
+		scheduledActions := EksConfig.ScheduledScaling[EksConfig.LinuxNodegroups[key]["name"]]
+		for _, placement := range placements {
+			nodeGroupName := EksConfig.LinuxNodegroups[key]["name"] + placement.suffix
+
+			nodeGroupArgs := &awseks.NodeGroupArgs{
+				ClusterName:   clusterName,
+				NodeGroupName: pulumi.String(nodeGroupName),
+				NodeRoleArn:   pulumi.StringInput(linuxNodeGroupRoles[key].Arn),
+				SubnetIds:     placement.subnetIds,
+				InstanceTypes: pulumi.StringArray{pulumi.String(EksConfig.LinuxNodegroups[key]["instanceType"])},
+				AmiType:       pulumi.String(EksConfig.LinuxNodegroups[key]["amiType"]),
+				// Follows the control plane, so the nodes are rolled when the cluster is upgraded
+				Version: pulumi.String(EksConfig.Version),
+				// The sizes are split across the groups of the node group
+				ScalingConfig: &awseks.NodeGroupScalingConfigArgs{
+					DesiredSize: pulumi.Int(placementSize(desiredSize, len(placements))),
+					MaxSize:     pulumi.Int(placementSize(maxSize, len(placements))),
+					MinSize:     pulumi.Int(placementSize(minSize, len(placements))),
+				},
+				Tags: pulumi.StringMap(CommonTags),
+			}
+
+			var imageId pulumi.StringPtrInput
+			var userData pulumi.StringPtrInput
+			if maxPods > 0 {
+				userData = pulumi.String(generateLinuxMaxPodsUserData(EksConfig.LinuxNodegroups[key]["amiType"], maxPods))
+			}
+			if amiId != "" {
+				// Custom AMIs have no EKS version nor AMI type, the user data joins the node to the cluster
+				imageId = pulumi.String(amiId)
+				nodeGroupArgs.AmiType = pulumi.String("CUSTOM")
+				nodeGroupArgs.Version = nil
+				amiType := EksConfig.LinuxNodegroups[key]["amiType"]
+				networkConfig := eksCluster.EksCluster.KubernetesNetworkConfig()
+				userData = pulumi.All(clusterName, eksCluster.EksCluster.Endpoint(), eksCluster.EksCluster.CertificateAuthority().Data(), networkConfig.ServiceIpv4Cidr(), networkConfig.ServiceIpv6Cidr()).ApplyT(
+					func(args []interface{}) string {
+						serviceCidr := args[3].(*string)
+						if ipFamily(EksConfig) == "ipv6" {
+							serviceCidr = args[4].(*string)
+						}
+						return generateLinuxCustomAmiUserData(amiType, args[0].(string), args[1].(string), *args[2].(*string), ipFamily(EksConfig), *serviceCidr, nodeGroupName, maxPods)
+					},
+				).(pulumi.StringOutput)
+			}
+
+			if userData != nil {
+				// max-pods and custom AMIs can only be set through a launch template,
+				// which then has to carry the disk and the SSH key as well
+				linuxLaunchTemplate, err := ec2.NewLaunchTemplate(ctx, nodeGroupName+"-launch-template", &ec2.LaunchTemplateArgs{
+					Name: pulumi.String(nodeGroupName + "-launch-template"),
+					BlockDeviceMappings: ec2.LaunchTemplateBlockDeviceMappingArray{
+						&ec2.LaunchTemplateBlockDeviceMappingArgs{
+							DeviceName: pulumi.String("/dev/xvda"),
+							Ebs: &ec2.LaunchTemplateBlockDeviceMappingEbsArgs{
+								VolumeSize:          pulumi.Int(diskSize),
+								VolumeType:          pulumi.String("gp2"),
+								DeleteOnTermination: pulumi.String("true"),
+							},
+						},
+					},
+					ImageId:  imageId,
+					KeyName:  pulumi.String(EksConfig.LinuxNodegroups[key]["sshKey"]),
+					UserData: userData,
+					TagSpecifications: ec2.LaunchTemplateTagSpecificationArray{
+						&ec2.LaunchTemplateTagSpecificationArgs{
+							ResourceType: pulumi.String("instance"),
+							Tags:         pulumi.StringMap(CommonTags),
+						},
+					},
+				})
+				errorHandler(err)
+				nodeGroupArgs.LaunchTemplate = &awseks.NodeGroupLaunchTemplateArgs{
+					Id:      linuxLaunchTemplate.ID(),
+					Version: pulumi.Sprintf("%v", linuxLaunchTemplate.LatestVersion),
+				}
+			} else {
+				nodeGroupArgs.DiskSize = pulumi.Int(diskSize)
+				nodeGroupArgs.RemoteAccess = &awseks.NodeGroupRemoteAccessArgs{
+					Ec2SshKey: pulumi.String(EksConfig.LinuxNodegroups[key]["sshKey"]),
+				}
+			}
+
+			// Creating the node group
+			// The scheduled actions move the desired size, which is then left to them
+			nodeGroupOptions := []pulumi.ResourceOption{pulumi.DependsOn(dependsOn)}
+			if len(scheduledActions) > 0 {
+				nodeGroupOptions = append(nodeGroupOptions, pulumi.IgnoreChanges([]string{"scalingConfig.desiredSize"}))
+			}
+			nodeGroup, err := awseks.NewNodeGroup(ctx, nodeGroupName, nodeGroupArgs, nodeGroupOptions...)
+			errorHandler(err)
+			err = createTerminationHook(ctx, EksConfig, nodeGroupName, managedNodeGroupAsgName(nodeGroup))
+			errorHandler(err)
+			err = createScheduledActions(ctx, nodeGroupName, scheduledActions, len(placements), managedNodeGroupAsgName(nodeGroup))
+			errorHandler(err)
+			nodeGroups = append(nodeGroups, nodeGroup)
+		}
 
 	}
 	return nodeGroups
 }
 
-func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, vpc *ec2.Vpc, subnets []*ec2.Subnet, eksCluster *eks.Cluster, EksOutput *EksOutput) []*autoscaling.Group {
+func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, vpc *ec2.Vpc, subnets []*ec2.Subnet, nodeSubnets map[string]NodeSubnets, eksCluster *eks.Cluster, EksOutput *EksOutput) []*autoscaling.Group {
 
 	conf := config.New(ctx, "")
 	windowsNodeGroups := []*autoscaling.Group{}
+	EksOutput.WindowsNodeGroupRoles = map[string]*iam.Role{}
 	// AMI lookup for the optimized version of the cluster
 	windowsAMI, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
 		Name: "/aws/service/ami-windows-latest/Windows_Server-2019-English-Core-EKS_Optimized-" + EksConfig.Version + "/image_id",
@@ -397,6 +728,15 @@ func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTa
 
 	sgID := eksCluster.EksCluster.VpcConfig().ClusterSecurityGroupId().Elem()
 
+	// The Windows nodes are refreshed last, once the Linux nodes and the add-ons are done
+	windowsDependencies := []pulumi.Resource{}
+	for _, nodeGroup := range EksOutput.LinuxNodeGroups {
+		windowsDependencies = append(windowsDependencies, nodeGroup)
+	}
+	for _, addon := range EksOutput.Addons {
+		windowsDependencies = append(windowsDependencies, addon)
+	}
+
 	for key := range EksConfig.WindowsNodegroups {
 
 		desiredSize, err := strconv.Atoi(EksConfig.WindowsNodegroups[key]["desiredSize"])
@@ -477,6 +817,7 @@ func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTa
 
 		// Exporting the role ARN for the aws-auth configMap
 		ctx.Export(EksConfig.WindowsNodegroups[key]["name"]+"-role-arn", windowsNodeGroupRole.Arn)
+		EksOutput.WindowsNodeGroupRoles[key] = windowsNodeGroupRole
 
 		// attachment of policies to the nodegroup Role
 		windowsNodeGroupPolicies := []string{
@@ -503,15 +844,47 @@ func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTa
 			return name
 		}).(pulumi.StringOutput)
 
+		maxPods, err := nodeGroupMaxPods(ctx, EksConfig, EksConfig.WindowsNodegroups[key], true, false)
+		errorHandler(err)
+
+		// The images are either pulled by the user data on each boot, or baked into the AMI
+		parentImage := windowsAMI.Value
+		if EksConfig.WindowsNodegroups[key]["amiId"] != "" {
+			parentImage = EksConfig.WindowsNodegroups[key]["amiId"]
+		}
+		imageId := pulumi.String(parentImage).ToStringOutput()
+		bootstrapPrePullImages := []string{}
+		switch EksConfig.WindowsNodegroups[key]["prePullMode"] {
+		case "bake":
+			imageId, err = createWindowsBakedImage(ctx, CommonTags, EksConfig.WindowsNodegroups[key], parentImage, conf.Require("region"), vpc, subnets[0])
+			errorHandler(err)
+		default:
+			bootstrapPrePullImages = prePullImages(EksConfig.WindowsNodegroups[key])
+		}
+
+		warmPool, err := windowsWarmPool(EksConfig.WindowsNodegroups[key])
+		errorHandler(err)
+		launchHook := windowsLaunchHookName(EksConfig.WindowsNodegroups[key])
+
 		templateb64encoded := pulumi.All(clusterName, conf.Require("region")).ApplyT(
 			func(args []interface{}) (string, error) {
 				clusterName := args[0].(string)
 				region := args[1].(string)
-				return generatePowershellTemplate(clusterName, region), err
+				return generatePowershellTemplate(clusterName, region, maxPods, bootstrapPrePullImages, launchHook), err
 			},
 		).(pulumi.StringOutput)
 		errorHandler(err)
 
+		// Hibernated instances keep their memory on an encrypted root volume
+		var hibernationOptions ec2.LaunchTemplateHibernationOptionsPtrInput
+		var rootVolumeEncrypted pulumi.StringPtrInput
+		if EksConfig.WindowsNodegroups[key]["warmPoolState"] == "Hibernated" {
+			hibernationOptions = &ec2.LaunchTemplateHibernationOptionsArgs{
+				Configured: pulumi.Bool(true),
+			}
+			rootVolumeEncrypted = pulumi.String("true")
+		}
+
 		windowsLaunchTemplate, err := ec2.NewLaunchTemplate(ctx, EksConfig.WindowsNodegroups[key]["name"]+"-launch-template", &ec2.LaunchTemplateArgs{
 			Name: pulumi.String(EksConfig.WindowsNodegroups[key]["name"] + "-launch-template"),
 			BlockDeviceMappings: ec2.LaunchTemplateBlockDeviceMappingArray{
@@ -521,13 +894,15 @@ func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTa
 						VolumeSize:          pulumi.Int(diskSize),
 						VolumeType:          pulumi.String("gp2"),
 						DeleteOnTermination: pulumi.String("true"),
+						Encrypted:           rootVolumeEncrypted,
 					},
 				},
 			},
+			HibernationOptions: hibernationOptions,
 			IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileArgs{
 				Name: windowsInstanceProfile.Name,
 			},
-			ImageId:      pulumi.String(windowsAMI.Value),
+			ImageId:      imageId,
 			InstanceType: pulumi.String(EksConfig.WindowsNodegroups[key]["instanceType"]),
 			KeyName:      pulumi.String(EksConfig.WindowsNodegroups[key]["sshKey"]),
 			VpcSecurityGroupIds: pulumi.StringArray{
@@ -553,44 +928,75 @@ func createWindowsNodeGroups(ctx *pulumi.Context, EksConfig *EksConfig, CommonTa
 			return "k8s.io/cluster-autoscaler/" + name
 		}).(pulumi.StringOutput)
 
-		windowsAutoscalingGroup, err := autoscaling.NewGroup(ctx, EksConfig.WindowsNodegroups[key]["name"], &autoscaling.GroupArgs{
-			Name:            pulumi.String(EksConfig.WindowsNodegroups[key]["name"]),
-			DesiredCapacity: pulumi.Int(desiredSize),
-			MaxSize:         pulumi.Int(maxSize),
-			MinSize:         pulumi.Int(minSize),
-			LaunchTemplate: &autoscaling.GroupLaunchTemplateArgs{
-				Id:      windowsLaunchTemplate.ID(),
-				Version: pulumi.String(fmt.Sprintf("%v%v", "$", "Latest")),
-			},
-			VpcZoneIdentifiers: getSubnetIds(subnets),
-			InstanceRefresh: &autoscaling.GroupInstanceRefreshArgs{
-				Strategy: pulumi.String("Rolling")},
-			Tags: autoscaling.GroupTagArray{
-				&autoscaling.GroupTagArgs{
-					Key:               pulumi.String("Name"),
-					Value:             pulumi.String("windows-autoscaling-nodegroup"),
-					PropagateAtLaunch: pulumi.Bool(true),
-				},
-				&autoscaling.GroupTagArgs{
-					Key:               clusterNameTag,
-					Value:             pulumi.String("owned"),
-					PropagateAtLaunch: pulumi.Bool(true),
-				},
-				&autoscaling.GroupTagArgs{
-					Key:               clusterTag,
-					Value:             pulumi.String("owned"),
-					PropagateAtLaunch: pulumi.Bool(true),
+		if launchHook != "" {
+			err = createWindowsLaunchHookPolicy(ctx, EksConfig.WindowsNodegroups[key], windowsNodeGroupRole)
+			errorHandler(err)
+		}
+
+		// One group per placement, suffixed with its AZ when the node group has one group per AZ
+		placements, err := nodeGroupPlacements(EksConfig.WindowsNodegroups[key], nodeSubnets)
+		errorHandler(err)
+		scheduledActions := EksConfig.ScheduledScaling[EksConfig.WindowsNodegroups[key]["name"]]
+		for _, placement := range placements {
+			autoScalingGroupName := EksConfig.WindowsNodegroups[key]["name"] + placement.suffix
+
+			// The scheduled actions move the desired capacity, which is then left to them
+			windowsGroupOptions := []pulumi.ResourceOption{pulumi.DependsOn(windowsDependencies)}
+			if len(scheduledActions) > 0 {
+				windowsGroupOptions = append(windowsGroupOptions, pulumi.IgnoreChanges([]string{"desiredCapacity"}))
+			}
+			// The sizes are split across the groups of the node group
+			windowsAutoscalingGroup, err := autoscaling.NewGroup(ctx, autoScalingGroupName, &autoscaling.GroupArgs{
+				Name:            pulumi.String(autoScalingGroupName),
+				DesiredCapacity: pulumi.Int(placementSize(desiredSize, len(placements))),
+				MaxSize:         pulumi.Int(placementSize(maxSize, len(placements))),
+				MinSize:         pulumi.Int(placementSize(minSize, len(placements))),
+				LaunchTemplate: &autoscaling.GroupLaunchTemplateArgs{
+					Id: windowsLaunchTemplate.ID(),
+					// A new launch template version (new AMI after an upgrade) changes the group and starts the instance refresh
+					Version: pulumi.Sprintf("%v", windowsLaunchTemplate.LatestVersion),
 				},
-				&autoscaling.GroupTagArgs{
-					Key:               pulumi.String("k8s.io/cluster-autoscaler/enabled"),
-					Value:             pulumi.String("true"),
-					PropagateAtLaunch: pulumi.Bool(true),
+				VpcZoneIdentifiers: placement.subnetIds,
+				InstanceRefresh: &autoscaling.GroupInstanceRefreshArgs{
+					Strategy: pulumi.String("Rolling")},
+				WarmPool:              warmPool,
+				InitialLifecycleHooks: windowsInitialLifecycleHooks(EksConfig.WindowsNodegroups[key]),
+				Tags: autoscaling.GroupTagArray{
+					&autoscaling.GroupTagArgs{
+						Key:               pulumi.String("Name"),
+						Value:             pulumi.String("windows-autoscaling-nodegroup"),
+						PropagateAtLaunch: pulumi.Bool(true),
+					},
+					&autoscaling.GroupTagArgs{
+						Key:               clusterNameTag,
+						Value:             pulumi.String("owned"),
+						PropagateAtLaunch: pulumi.Bool(true),
+					},
+					&autoscaling.GroupTagArgs{
+						Key:               clusterTag,
+						Value:             pulumi.String("owned"),
+						PropagateAtLaunch: pulumi.Bool(true),
+					},
+					&autoscaling.GroupTagArgs{
+						Key:               pulumi.String("k8s.io/cluster-autoscaler/enabled"),
+						Value:             pulumi.String("true"),
+						PropagateAtLaunch: pulumi.Bool(true),
+					},
 				},
-			},
-		})
-		errorHandler(err)
+			}, windowsGroupOptions...)
+			errorHandler(err)
 
-		windowsNodeGroups = append(windowsNodeGroups, windowsAutoscalingGroup)
+			if launchHook != "" {
+				err = createWindowsLaunchHook(ctx, EksConfig.WindowsNodegroups[key], autoScalingGroupName, windowsAutoscalingGroup)
+				errorHandler(err)
+			}
+			err = createTerminationHook(ctx, EksConfig, autoScalingGroupName, windowsAutoscalingGroup.Name)
+			errorHandler(err)
+			err = createScheduledActions(ctx, autoScalingGroupName, scheduledActions, len(placements), windowsAutoscalingGroup.Name)
+			errorHandler(err)
+
+			windowsNodeGroups = append(windowsNodeGroups, windowsAutoscalingGroup)
+		}
 	}
 
 	return windowsNodeGroups
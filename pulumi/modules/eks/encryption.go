@@ -0,0 +1,85 @@
+package eks
+
+import (
+	"encoding/json"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/kms"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type SecretsEncryptionConfig struct {
+	Enabled bool
+	// ARN of an existing key. A key with yearly rotation is created when empty
+	KmsKeyArn string
+	// Waiting period before the created key is deleted, 30 days when 0
+	DeletionWindowInDays int
+}
+
+// secretsEncryptionKey returns the ARN of the KMS key used for the envelope encryption of the Kubernetes secrets,
+// creating it when no existing key is given, and allows the cluster role to use it.
+// It returns nil when the encryption is disabled, and the role policy the cluster has to wait for otherwise.
+func secretsEncryptionKey(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, eksRole *iam.Role) (pulumi.StringPtrInput, []pulumi.Resource, error) {
+	secretsEncryption := EksConfig.SecretsEncryption
+	if !secretsEncryption.Enabled {
+		return nil, nil, nil
+	}
+
+	var keyArn pulumi.StringOutput
+	if secretsEncryption.KmsKeyArn != "" {
+		keyArn = pulumi.String(secretsEncryption.KmsKeyArn).ToStringOutput()
+	} else {
+		deletionWindowInDays := secretsEncryption.DeletionWindowInDays
+		if deletionWindowInDays == 0 {
+			deletionWindowInDays = 30
+		}
+		// Protected, since losing the key means losing every secret of the cluster
+		secretsKey, err := kms.NewKey(ctx, EksConfig.Name+"-secrets-kms-key", &kms.KeyArgs{
+			Description:          pulumi.String("Envelope encryption of the Kubernetes secrets of " + EksConfig.Name + " EKS cluster"),
+			EnableKeyRotation:    pulumi.Bool(true),
+			DeletionWindowInDays: pulumi.Int(deletionWindowInDays),
+			Tags:                 pulumi.StringMap(CommonTags),
+		}, pulumi.Protect(true))
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = kms.NewAlias(ctx, EksConfig.Name+"-secrets-kms-key-alias", &kms.AliasArgs{
+			Name:        pulumi.String("alias/" + EksConfig.Name + "-eks-secrets"),
+			TargetKeyId: secretsKey.KeyId,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		keyArn = secretsKey.Arn
+	}
+
+	secretsKeyPolicyJson := keyArn.ApplyT(func(keyArn string) (string, error) {
+		policyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Action": []string{
+						"kms:Encrypt",
+						"kms:Decrypt",
+						"kms:ListGrants",
+						"kms:DescribeKey",
+					},
+					"Effect":   "Allow",
+					"Resource": keyArn,
+				},
+			},
+		})
+		return string(policyJson), err
+	}).(pulumi.StringOutput)
+
+	secretsKeyPolicy, err := iam.NewRolePolicy(ctx, EksConfig.Name+"-eks-role-secrets-kms-key", &iam.RolePolicyArgs{
+		Role:   eksRole.Name,
+		Policy: secretsKeyPolicyJson,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx.Export("secrets-kms-key-arn", keyArn)
+	return keyArn, []pulumi.Resource{secretsKeyPolicy}, nil
+}
@@ -0,0 +1,198 @@
+package eks
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	eks "github.com/pulumi/pulumi-eks/sdk/go/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/voltrondata/pulumi-go-modules/shared/utilities"
+)
+
+// Instance type used by the bastion when bastion.instanceType is not set
+const defaultBastionInstanceType = "t3.micro"
+
+type EndpointConfig struct {
+	// "public" (default), "public-and-private" or "private"
+	Access string
+	// CIDRs allowed to reach the public endpoint, 0.0.0.0/0 when empty
+	PublicAccessCidrs []string
+	// CIDRs allowed to reach the private endpoint on 443 (VPN, peered networks, CI deploy runners).
+	// The nodes and the pods can always reach it
+	PrivateAccessCidrs []string
+	Bastion            BastionConfig
+}
+
+type BastionConfig struct {
+	Enabled      bool
+	InstanceType string
+}
+
+// validateEndpoint checks the endpoint access settings before anything is created
+func validateEndpoint(EksConfig *EksConfig) error {
+	endpoint := EksConfig.Endpoint
+	switch endpointAccess(EksConfig) {
+	case "public":
+		if len(endpoint.PrivateAccessCidrs) > 0 || endpoint.Bastion.Enabled {
+			return fmt.Errorf("eks %s: privateAccessCidrs and bastion need the private endpoint, set endpoint.access to private or public-and-private", EksConfig.Name)
+		}
+	case "public-and-private":
+	case "private":
+		if len(endpoint.PublicAccessCidrs) > 0 {
+			return fmt.Errorf("eks %s: publicAccessCidrs can't be set when the endpoint is private", EksConfig.Name)
+		}
+	default:
+		return fmt.Errorf("eks %s: unknown endpoint access %q, must be public, public-and-private or private", EksConfig.Name, endpoint.Access)
+	}
+	return nil
+}
+
+// endpointAccess returns the configured endpoint access, defaulting to public
+func endpointAccess(EksConfig *EksConfig) string {
+	if EksConfig.Endpoint.Access == "" {
+		return "public"
+	}
+	return EksConfig.Endpoint.Access
+}
+
+// publicAccessCidrs returns the CIDRs allowed to reach the public endpoint, or nil when the endpoint is private
+func publicAccessCidrs(EksConfig *EksConfig) pulumi.StringArrayInput {
+	if endpointAccess(EksConfig) == "private" {
+		return nil
+	}
+	if len(EksConfig.Endpoint.PublicAccessCidrs) == 0 {
+		return pulumi.StringArray{pulumi.String("0.0.0.0/0")}
+	}
+	return pulumi.ToStringArray(EksConfig.Endpoint.PublicAccessCidrs)
+}
+
+// createPrivateEndpointAccess opens the private endpoint, through the cluster security group, to the configured CIDRs
+// and to the SSM bastion when enabled. Nothing is created when the endpoint is public only.
+func createPrivateEndpointAccess(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, vpc *ec2.Vpc, subnets []*ec2.Subnet, eksCluster *eks.Cluster) error {
+	if endpointAccess(EksConfig) == "public" {
+		return nil
+	}
+
+	sgID := eksCluster.EksCluster.VpcConfig().ClusterSecurityGroupId().Elem()
+
+	if len(EksConfig.Endpoint.PrivateAccessCidrs) > 0 {
+		_, err := ec2.NewSecurityGroupRule(ctx, EksConfig.Name+"-private-endpoint-cidrs-ingress", &ec2.SecurityGroupRuleArgs{
+			Type:            pulumi.String("ingress"),
+			Description:     pulumi.String("Kubernetes API from the private access CIDRs"),
+			FromPort:        pulumi.Int(443),
+			ToPort:          pulumi.Int(443),
+			Protocol:        pulumi.String("tcp"),
+			SecurityGroupId: sgID,
+			CidrBlocks:      pulumi.ToStringArray(EksConfig.Endpoint.PrivateAccessCidrs),
+		}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !EksConfig.Endpoint.Bastion.Enabled {
+		return nil
+	}
+	bastionSg, err := createBastion(ctx, EksConfig, CommonTags, vpc, subnets[0])
+	if err != nil {
+		return err
+	}
+	_, err = ec2.NewSecurityGroupRule(ctx, EksConfig.Name+"-private-endpoint-bastion-ingress", &ec2.SecurityGroupRuleArgs{
+		Type:                  pulumi.String("ingress"),
+		Description:           pulumi.String("Kubernetes API from the SSM bastion"),
+		FromPort:              pulumi.Int(443),
+		ToPort:                pulumi.Int(443),
+		Protocol:              pulumi.String("tcp"),
+		SecurityGroupId:       sgID,
+		SourceSecurityGroupId: bastionSg.ID(),
+	}, pulumi.DependsOn([]pulumi.Resource{eksCluster}))
+	return err
+}
+
+// createBastion creates an instance without any inbound rule, only reachable through SSM sessions,
+// used to forward a local port to the private endpoint of the cluster. It returns its security group.
+func createBastion(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, vpc *ec2.Vpc, subnet *ec2.Subnet) (*ec2.SecurityGroup, error) {
+	instanceType := EksConfig.Endpoint.Bastion.InstanceType
+	if instanceType == "" {
+		instanceType = defaultBastionInstanceType
+	}
+
+	architecture := utilities.InstanceTypeArchitecture(instanceType)
+
+	bastionAMI, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+		Name: "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-" + architecture,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	bastionSg, err := ec2.NewSecurityGroup(ctx, EksConfig.Name+"-bastion-sg", &ec2.SecurityGroupArgs{
+		Name:        pulumi.String(EksConfig.Name + "-bastion-sg"),
+		Description: pulumi.String("SSM bastion of the EKS cluster, no inbound"),
+		VpcId:       vpc.ID(),
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Tags: pulumi.StringMap(CommonTags),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bastionRole, err := iam.NewRole(ctx, EksConfig.Name+"-bastion-role", &iam.RoleArgs{
+		Name:        pulumi.String(EksConfig.Name + "-bastion-role"),
+		Description: pulumi.String("Role used by the SSM bastion of " + EksConfig.Name + " EKS cluster"),
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Sid": "",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "ec2.amazonaws.com"
+				},
+				"Action": "sts:AssumeRole"
+			}]
+		}`),
+		ManagedPolicyArns: pulumi.StringArray{
+			pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+		},
+		Tags: pulumi.StringMap(CommonTags),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bastionInstanceProfile, err := iam.NewInstanceProfile(ctx, EksConfig.Name+"-bastion-instance-profile", &iam.InstanceProfileArgs{
+		Name: pulumi.String(EksConfig.Name + "-bastion-instance-profile"),
+		Role: bastionRole.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bastion, err := ec2.NewInstance(ctx, EksConfig.Name+"-bastion", &ec2.InstanceArgs{
+		Ami:                 pulumi.String(bastionAMI.Value),
+		InstanceType:        pulumi.String(instanceType),
+		SubnetId:            subnet.ID(),
+		VpcSecurityGroupIds: pulumi.StringArray{bastionSg.ID()},
+		IamInstanceProfile:  bastionInstanceProfile.Name,
+		MetadataOptions: &ec2.InstanceMetadataOptionsArgs{
+			HttpEndpoint: pulumi.String("enabled"),
+			HttpTokens:   pulumi.String("required"),
+		},
+		Tags: addNameToCommonTags(EksConfig.Name+"-bastion", CommonTags),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Export("bastion-instance-id", bastion.ID())
+	return bastionSg, nil
+}
@@ -2,12 +2,16 @@ module github.com/voltrondata/pulumi-go-modules/AWS/eks
 
 go 1.18
 
+replace github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder => ../imagebuilder
+
 replace github.com/voltrondata/pulumi-go-modules/shared/utilities => ../utilities
 
 require (
 	github.com/pulumi/pulumi-aws/sdk/v5 v5.42.0
 	github.com/pulumi/pulumi-eks/sdk v0.42.7
+	github.com/pulumi/pulumi-kubernetes/sdk/v3 v3.17.0
 	github.com/pulumi/pulumi/sdk/v3 v3.80.0
+	github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder v0.1.0
 	github.com/voltrondata/pulumi-go-modules/shared/utilities v0.1.0
 )
 
@@ -58,7 +62,6 @@ require (
 	github.com/pjbgf/sha1cd v0.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pkg/term v1.1.0 // indirect
-	github.com/pulumi/pulumi-kubernetes/sdk/v3 v3.17.0 // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/rogpeppe/go-internal v1.9.0 // indirect
 	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06 // indirect
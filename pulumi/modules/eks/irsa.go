@@ -0,0 +1,55 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	awseks "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// NewIrsaRole creates a role that the given service account can assume through the OIDC provider of the cluster (IRSA),
+// with the given managed policies attached.
+func NewIrsaRole(ctx *pulumi.Context, roleName string, eksCluster awseks.ClusterOutput, namespace string, serviceAccount string, policyArns pulumi.StringArray, CommonTags pulumi.StringMap) (*iam.Role, error) {
+	currentCaller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	assumeRolePolicyJson := eksCluster.Identities().ApplyT(func(identities []awseks.ClusterIdentity) (string, error) {
+		oidcUrl := *identities[0].Oidcs[0].Issuer
+		oidcName := strings.ReplaceAll(oidcUrl, "https://", "")
+		oidcArn := fmt.Sprintf("arn:aws:iam::%s:oidc-provider/%s", currentCaller.AccountId, oidcName)
+
+		assumeRolePolicyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Action": "sts:AssumeRoleWithWebIdentity",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Federated": oidcArn,
+					},
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							fmt.Sprintf("%s:sub", oidcName): fmt.Sprintf("system:serviceaccount:%s:%s", namespace, serviceAccount),
+							fmt.Sprintf("%s:aud", oidcName): "sts.amazonaws.com",
+						},
+					},
+				},
+			},
+		})
+		return string(assumeRolePolicyJson), err
+	}).(pulumi.StringOutput)
+
+	return iam.NewRole(ctx, roleName, &iam.RoleArgs{
+		Name:              pulumi.String(roleName),
+		Description:       pulumi.String("Role assumed by the " + namespace + "/" + serviceAccount + " service account"),
+		AssumeRolePolicy:  assumeRolePolicyJson,
+		ManagedPolicyArns: policyArns,
+		Tags:              pulumi.StringMap(CommonTags),
+	})
+}
@@ -0,0 +1,55 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	eks "github.com/pulumi/pulumi-eks/sdk/go/eks"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/apiextensions"
+	metav1 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/meta/v1"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// newKubernetesProvider returns a Kubernetes provider that talks to the API server of the cluster,
+// for the in-cluster objects the module manages
+func newKubernetesProvider(ctx *pulumi.Context, eksCluster *eks.Cluster) *kubernetes.Provider {
+	kubeconfig := eksCluster.Kubeconfig.ApplyT(func(kubeconfig interface{}) (string, error) {
+		kubeconfigJson, err := json.Marshal(kubeconfig)
+		return string(kubeconfigJson), err
+	}).(pulumi.StringOutput)
+
+	k8sProvider, err := kubernetes.NewProvider(ctx, "eks-k8s-provider", &kubernetes.ProviderArgs{
+		Kubeconfig: kubeconfig,
+	})
+	errorHandler(err)
+	return k8sProvider
+}
+
+// createEniConfigs creates one ENIConfig per pod subnet, named after its AZ. With custom networking enabled
+// (and ENI_CONFIG_LABEL_DEF set to the zone label), the VPC CNI of each node picks the ENIConfig of its AZ
+// and takes the pod IPs from that subnet instead of the node subnet.
+func createEniConfigs(ctx *pulumi.Context, k8sProvider *kubernetes.Provider, eksCluster *eks.Cluster, podSubnets []*ec2.Subnet) []pulumi.Resource {
+	sgID := eksCluster.EksCluster.VpcConfig().ClusterSecurityGroupId().Elem()
+
+	eniConfigs := []pulumi.Resource{}
+	for index, podSubnet := range podSubnets {
+		eniConfig, err := apiextensions.NewCustomResource(ctx, fmt.Sprintf("eniconfig-0%d", index), &apiextensions.CustomResourceArgs{
+			ApiVersion: pulumi.String("crd.k8s.amazonaws.com/v1alpha1"),
+			Kind:       pulumi.String("ENIConfig"),
+			Metadata: &metav1.ObjectMetaArgs{
+				Name: podSubnet.AvailabilityZone,
+			},
+			OtherFields: kubernetes.UntypedArgs{
+				"spec": pulumi.Map{
+					"subnet":         podSubnet.ID(),
+					"securityGroups": pulumi.StringArray{sgID},
+				},
+			},
+		}, pulumi.Provider(k8sProvider))
+		errorHandler(err)
+		eniConfigs = append(eniConfigs, eniConfig)
+	}
+	return eniConfigs
+}
@@ -0,0 +1,71 @@
+package eks
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Retention of the control plane logs when retentionDays is not set
+const defaultControlPlaneLogsRetentionDays = 90
+
+var controlPlaneLogTypes = map[string]bool{
+	"api":               true,
+	"audit":             true,
+	"authenticator":     true,
+	"controllerManager": true,
+	"scheduler":         true,
+}
+
+type ControlPlaneLogsConfig struct {
+	// Any of api, audit, authenticator, controllerManager and scheduler. Logging is disabled when empty
+	Types []string
+	// Days before the logs are deleted, 90 when 0
+	RetentionDays int
+	// KMS key used to encrypt the log group. Its key policy has to allow the CloudWatch Logs service
+	KmsKeyArn string
+}
+
+// validateControlPlaneLogs checks the control plane log types before anything is created
+func validateControlPlaneLogs(EksConfig *EksConfig) error {
+	for _, logType := range EksConfig.ControlPlaneLogs.Types {
+		if !controlPlaneLogTypes[logType] {
+			return fmt.Errorf("eks %s: unknown control plane log type %q, must be api, audit, authenticator, controllerManager or scheduler", EksConfig.Name, logType)
+		}
+	}
+	return nil
+}
+
+// createControlPlaneLogGroup creates the log group EKS writes the control plane logs to, before the cluster does it
+// with an infinite retention. It returns the enabled log types and the log group the cluster has to wait for,
+// or nil when logging is disabled.
+func createControlPlaneLogGroup(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap) (pulumi.StringArrayInput, []pulumi.Resource, error) {
+	controlPlaneLogs := EksConfig.ControlPlaneLogs
+	if len(controlPlaneLogs.Types) == 0 {
+		return nil, nil, nil
+	}
+
+	retentionDays := controlPlaneLogs.RetentionDays
+	if retentionDays == 0 {
+		retentionDays = defaultControlPlaneLogsRetentionDays
+	}
+	var kmsKeyId pulumi.StringPtrInput
+	if controlPlaneLogs.KmsKeyArn != "" {
+		kmsKeyId = pulumi.String(controlPlaneLogs.KmsKeyArn)
+	}
+
+	// EKS only writes to this name
+	logGroup, err := cloudwatch.NewLogGroup(ctx, EksConfig.Name+"-control-plane-log-group", &cloudwatch.LogGroupArgs{
+		Name:            pulumi.String("/aws/eks/" + EksConfig.Name + "/cluster"),
+		RetentionInDays: pulumi.Int(retentionDays),
+		KmsKeyId:        kmsKeyId,
+		Tags:            pulumi.StringMap(CommonTags),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx.Export("control-plane-log-group", logGroup.Name)
+	return pulumi.ToStringArray(controlPlaneLogs.Types), []pulumi.Resource{logGroup}, nil
+}
@@ -0,0 +1,116 @@
+package eks
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// IPs per /28 prefix assigned to an ENI slot when prefix delegation is enabled
+const ipsPerPrefix = 16
+
+// nodeGroupMaxPods returns the max-pods value of a node group: the "maxPods" key of the node group when set,
+// otherwise computed from the ENI limits of its instance type when prefix delegation is enabled.
+// It returns 0 when the AMI default has to be kept.
+func nodeGroupMaxPods(ctx *pulumi.Context, EksConfig *EksConfig, nodeGroup map[string]string, windows bool, customNetworking bool) (int, error) {
+	if nodeGroup["maxPods"] != "" {
+		return strconv.Atoi(nodeGroup["maxPods"])
+	}
+	if !EksConfig.EnablePrefixDelegation {
+		return 0, nil
+	}
+
+	instanceType, err := ec2.GetInstanceType(ctx, &ec2.GetInstanceTypeArgs{
+		InstanceType: nodeGroup["instanceType"],
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Windows nodes only use the secondary IPs of their primary ENI, prefix delegation doesn't apply to them
+	if windows {
+		return instanceType.MaximumIpv4AddressesPerInterface - 1, nil
+	}
+
+	if instanceType.Hypervisor != "nitro" {
+		return 0, fmt.Errorf("eks %s: prefix delegation needs Nitro instances, %s node group uses %s", EksConfig.Name, nodeGroup["name"], nodeGroup["instanceType"])
+	}
+	return linuxMaxPods(instanceType.MaximumNetworkInterfaces, instanceType.MaximumIpv4AddressesPerInterface, instanceType.DefaultVcpus, customNetworking), nil
+}
+
+// linuxMaxPods follows the AWS max-pods calculator: every secondary IP slot of every ENI holds a /28 prefix,
+// plus the two host network pods (aws-node and kube-proxy). With custom networking the primary ENI is not used for pods.
+// The result is capped to the kubelet recommendation: 110 below 30 vCPUs, 250 above.
+func linuxMaxPods(enis int, ipsPerEni int, vcpus int, customNetworking bool) int {
+	if customNetworking {
+		enis--
+	}
+	maxPods := enis*(ipsPerEni-1)*ipsPerPrefix + 2
+
+	maxPodsCap := 110
+	if vcpus >= 30 {
+		maxPodsCap = 250
+	}
+	if maxPods > maxPodsCap {
+		return maxPodsCap
+	}
+	return maxPods
+}
+
+// isAl2AmiType tells if a Linux AMI type is an Amazon Linux 2 one (AL2_x86_64, AL2_x86_64_GPU, AL2_ARM_64)
+func isAl2AmiType(amiType string) bool {
+	return !strings.HasPrefix(amiType, "AL2023") && !strings.HasPrefix(amiType, "BOTTLEROCKET")
+}
+
+// eksOptimizedAl2AmiId returns the current EKS optimized AL2 AMI of an AMI type and Kubernetes version
+func eksOptimizedAl2AmiId(ctx *pulumi.Context, amiType string, kubernetesVersion string) (string, error) {
+	variant := "amazon-linux-2"
+	switch amiType {
+	case "AL2_ARM_64":
+		variant = "amazon-linux-2-arm64"
+	case "AL2_x86_64_GPU":
+		variant = "amazon-linux-2-gpu"
+	}
+	ami, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+		Name: "/aws/service/eks/optimized-ami/" + kubernetesVersion + "/" + variant + "/recommended/image_id",
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return ami.Value, nil
+}
+
+// generateLinuxMaxPodsUserData renders the user data that sets max-pods on the nodes of a managed node group,
+// merged by EKS with its own bootstrap. AL2 only takes max-pods through the bootstrap.sh arguments, so its
+// node groups run the EKS optimized AMI as a custom one instead (see generateLinuxCustomAmiUserData).
+func generateLinuxMaxPodsUserData(amiType string, maxPods int) string {
+	var userData string
+	if strings.HasPrefix(amiType, "BOTTLEROCKET") {
+		// Bottlerocket takes TOML settings instead of a MIME multi-part document
+		userData = fmt.Sprintf("[settings.kubernetes]\nmax-pods = %d\n", maxPods)
+	} else {
+		userData = fmt.Sprintf(`MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="//"
+
+--//
+Content-Type: application/node.eks.aws
+
+---
+apiVersion: node.eks.aws/v1alpha1
+kind: NodeConfig
+spec:
+  kubelet:
+    config:
+      maxPods: %d
+
+--//--
+`, maxPods)
+	}
+
+	return base64.StdEncoding.EncodeToString([]byte(userData))
+}
@@ -0,0 +1,47 @@
+package eks
+
+import "testing"
+
+func TestLinuxMaxPods(t *testing.T) {
+	tests := []struct {
+		name             string
+		enis             int
+		ipsPerEni        int
+		vcpus            int
+		customNetworking bool
+		want             int
+	}{
+		{name: "t3.micro", enis: 2, ipsPerEni: 2, vcpus: 2, want: 34},
+		{name: "t3.micro with custom networking", enis: 2, ipsPerEni: 2, vcpus: 2, customNetworking: true, want: 18},
+		{name: "m5.large capped to 110", enis: 3, ipsPerEni: 10, vcpus: 2, want: 110},
+		{name: "m5.8xlarge capped to 250", enis: 8, ipsPerEni: 30, vcpus: 32, want: 250},
+		{name: "single ENI with custom networking", enis: 1, ipsPerEni: 4, vcpus: 2, customNetworking: true, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := linuxMaxPods(tt.enis, tt.ipsPerEni, tt.vcpus, tt.customNetworking); got != tt.want {
+				t.Errorf("linuxMaxPods() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAl2AmiType(t *testing.T) {
+	tests := []struct {
+		amiType string
+		want    bool
+	}{
+		{amiType: "AL2_x86_64", want: true},
+		{amiType: "AL2_ARM_64", want: true},
+		{amiType: "AL2_x86_64_GPU", want: true},
+		{amiType: "AL2023_x86_64_STANDARD", want: false},
+		{amiType: "BOTTLEROCKET_ARM_64", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amiType, func(t *testing.T) {
+			if got := isAl2AmiType(tt.amiType); got != tt.want {
+				t.Errorf("isAl2AmiType(%s) = %v, want %v", tt.amiType, got, tt.want)
+			}
+		})
+	}
+}
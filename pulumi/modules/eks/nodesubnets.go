@@ -0,0 +1,133 @@
+package eks
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// NodeSubnets are the subnets of a tier (private, public, runner...) the node groups can be placed in,
+// with the AZ of each subnet in the same order
+type NodeSubnets struct {
+	Subnets           []*ec2.Subnet
+	AvailabilityZones []string
+}
+
+// nodeGroupPlacement is one group created for a node group config: the subnets it spans,
+// and the suffix of its name (the AZ when the node group has one group per AZ)
+type nodeGroupPlacement struct {
+	suffix    string
+	subnetIds pulumi.StringArray
+}
+
+// splitList returns the non empty items of a comma separated node group value
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// nodeGroupPlacements returns the groups of a node group config, from its "subnetIds", "subnetTier", "subnetAzs" and "perAz" keys.
+// By default a node group spans all the subnets of the private tier.
+func nodeGroupPlacements(nodeGroup map[string]string, nodeSubnets map[string]NodeSubnets) ([]nodeGroupPlacement, error) {
+	perAz := false
+	if nodeGroup["perAz"] != "" {
+		var err error
+		if perAz, err = strconv.ParseBool(nodeGroup["perAz"]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Explicit subnets, whatever their tier
+	if subnetIds := splitList(nodeGroup["subnetIds"]); len(subnetIds) > 0 {
+		if perAz || nodeGroup["subnetTier"] != "" || nodeGroup["subnetAzs"] != "" {
+			return nil, fmt.Errorf("subnetIds can't be combined with subnetTier, subnetAzs or perAz")
+		}
+		return []nodeGroupPlacement{{subnetIds: pulumi.ToStringArray(subnetIds)}}, nil
+	}
+
+	tier := nodeGroup["subnetTier"]
+	if tier == "" {
+		tier = "private"
+	}
+	tierSubnets, ok := nodeSubnets[tier]
+	if !ok || len(tierSubnets.Subnets) == 0 {
+		return nil, fmt.Errorf("no %s subnets", tier)
+	}
+	subnetAzs := splitList(nodeGroup["subnetAzs"])
+	if (perAz || len(subnetAzs) > 0) && len(tierSubnets.AvailabilityZones) != len(tierSubnets.Subnets) {
+		return nil, fmt.Errorf("the AZs of the %s subnets are unknown", tier)
+	}
+	selectedAzs := map[string]bool{}
+	for _, availabilityZone := range subnetAzs {
+		selectedAzs[availabilityZone] = true
+	}
+
+	subnetIdsByAz := map[string]pulumi.StringArray{}
+	subnetIds := pulumi.StringArray{}
+	for index, subnet := range tierSubnets.Subnets {
+		availabilityZone := ""
+		if len(tierSubnets.AvailabilityZones) == len(tierSubnets.Subnets) {
+			availabilityZone = tierSubnets.AvailabilityZones[index]
+		}
+		if len(selectedAzs) > 0 && !selectedAzs[availabilityZone] {
+			continue
+		}
+		subnetIdsByAz[availabilityZone] = append(subnetIdsByAz[availabilityZone], subnet.ID().ToStringOutput())
+		subnetIds = append(subnetIds, subnet.ID().ToStringOutput())
+	}
+	for _, availabilityZone := range subnetAzs {
+		if _, ok := subnetIdsByAz[availabilityZone]; !ok {
+			return nil, fmt.Errorf("no %s subnets in %s", tier, availabilityZone)
+		}
+	}
+
+	if !perAz {
+		return []nodeGroupPlacement{{subnetIds: subnetIds}}, nil
+	}
+	// Sorted, so the groups keep their names between runs
+	availabilityZones := []string{}
+	for availabilityZone := range subnetIdsByAz {
+		availabilityZones = append(availabilityZones, availabilityZone)
+	}
+	sort.Strings(availabilityZones)
+	placements := []nodeGroupPlacement{}
+	for _, availabilityZone := range availabilityZones {
+		placements = append(placements, nodeGroupPlacement{suffix: "-" + availabilityZone, subnetIds: subnetIdsByAz[availabilityZone]})
+	}
+	return placements, nil
+}
+
+// placementSize splits a size of the node group config across its groups, rounded up so the groups together
+// never get less than the configured size
+func placementSize(size int, placements int) int {
+	if placements <= 1 {
+		return size
+	}
+	return (size + placements - 1) / placements
+}
+
+// validateNodeGroupSubnets checks the subnet selection of every node group before creating anything
+func validateNodeGroupSubnets(EksConfig *EksConfig, nodeSubnets map[string]NodeSubnets) error {
+	nodeGroups := []map[string]string{}
+	for key := range EksConfig.LinuxNodegroups {
+		nodeGroups = append(nodeGroups, EksConfig.LinuxNodegroups[key])
+	}
+	for key := range EksConfig.WindowsNodegroups {
+		nodeGroups = append(nodeGroups, EksConfig.WindowsNodegroups[key])
+	}
+	for _, nodeGroup := range nodeGroups {
+		if _, err := nodeGroupPlacements(nodeGroup, nodeSubnets); err != nil {
+			return fmt.Errorf("eks %s: node group %s: %v", EksConfig.Name, nodeGroup["name"], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package eks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type mocks int
+
+func (mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	return args.Name + "_id", args.Inputs, nil
+}
+
+func (mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	return args.Args, nil
+}
+
+func TestNodeGroupPlacements(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodeGroup map[string]string
+		// subnet ids of every placement, by suffix
+		want    map[string][]string
+		wantErr bool
+	}{
+		{
+			name:      "private tier by default",
+			nodeGroup: map[string]string{},
+			want:      map[string][]string{"": {"private-a_id", "private-b_id", "private-c_id"}},
+		},
+		{
+			name:      "public tier",
+			nodeGroup: map[string]string{"subnetTier": "public"},
+			want:      map[string][]string{"": {"public-a_id", "public-b_id"}},
+		},
+		{
+			name:      "selected AZs",
+			nodeGroup: map[string]string{"subnetAzs": "us-east-2c, us-east-2a"},
+			want:      map[string][]string{"": {"private-a_id", "private-c_id"}},
+		},
+		{
+			name:      "one group per AZ",
+			nodeGroup: map[string]string{"perAz": "true"},
+			want: map[string][]string{
+				"-us-east-2a": {"private-a_id"},
+				"-us-east-2b": {"private-b_id"},
+				"-us-east-2c": {"private-c_id"},
+			},
+		},
+		{
+			name:      "one group per selected AZ",
+			nodeGroup: map[string]string{"perAz": "true", "subnetTier": "public", "subnetAzs": "us-east-2b"},
+			want:      map[string][]string{"-us-east-2b": {"public-b_id"}},
+		},
+		{
+			name:      "explicit subnets",
+			nodeGroup: map[string]string{"subnetIds": "subnet-1,subnet-2"},
+			want:      map[string][]string{"": {"subnet-1", "subnet-2"}},
+		},
+		{
+			name:      "explicit subnets with a tier",
+			nodeGroup: map[string]string{"subnetIds": "subnet-1", "subnetTier": "public"},
+			wantErr:   true,
+		},
+		{
+			name:      "unknown tier",
+			nodeGroup: map[string]string{"subnetTier": "runner"},
+			wantErr:   true,
+		},
+		{
+			name:      "AZ without subnets",
+			nodeGroup: map[string]string{"subnetTier": "public", "subnetAzs": "us-east-2c"},
+			wantErr:   true,
+		},
+		{
+			name:      "AZs of the tier unknown",
+			nodeGroup: map[string]string{"subnetTier": "pod", "perAz": "true"},
+			wantErr:   true,
+		},
+		{
+			name:      "invalid perAz",
+			nodeGroup: map[string]string{"perAz": "sometimes"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string][]string{}
+			err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+				subnets := func(names ...string) []*ec2.Subnet {
+					tierSubnets := []*ec2.Subnet{}
+					for _, name := range names {
+						subnet, err := ec2.NewSubnet(ctx, name, &ec2.SubnetArgs{VpcId: pulumi.String("vpc-1")})
+						if err != nil {
+							t.Fatal(err)
+						}
+						tierSubnets = append(tierSubnets, subnet)
+					}
+					return tierSubnets
+				}
+				nodeSubnets := map[string]NodeSubnets{
+					"private": {
+						Subnets:           subnets("private-a", "private-b", "private-c"),
+						AvailabilityZones: []string{"us-east-2a", "us-east-2b", "us-east-2c"},
+					},
+					"public": {
+						Subnets:           subnets("public-a", "public-b"),
+						AvailabilityZones: []string{"us-east-2a", "us-east-2b"},
+					},
+					"pod": {
+						Subnets: subnets("pod-a"),
+					},
+				}
+
+				placements, err := nodeGroupPlacements(tt.nodeGroup, nodeSubnets)
+				if err != nil {
+					return err
+				}
+				for _, placement := range placements {
+					suffix := placement.suffix
+					ctx.Export("placement"+suffix, placement.subnetIds.ToStringArrayOutput().ApplyT(func(subnetIds []string) []string {
+						got[suffix] = subnetIds
+						return subnetIds
+					}))
+				}
+				return nil
+			}, pulumi.WithMocks("project", "stack", mocks(0)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nodeGroupPlacements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeGroupPlacements() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlacementSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		placements int
+		want       int
+	}{
+		{name: "single group", size: 5, placements: 1, want: 5},
+		{name: "no group", size: 5, placements: 0, want: 5},
+		{name: "even split", size: 6, placements: 3, want: 2},
+		{name: "rounded up", size: 5, placements: 3, want: 2},
+		{name: "less than one per group", size: 1, placements: 3, want: 1},
+		{name: "zero", size: 0, placements: 3, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := placementSize(tt.size, tt.placements); got != tt.want {
+				t.Errorf("placementSize(%d, %d) = %d, want %d", tt.size, tt.placements, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package eks
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/autoscaling"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type ScheduledActionConfig struct {
+	Name string
+	// Cron expression, e.g. "0 7 * * MON-FRI"
+	Recurrence string
+	// IANA timezone of the recurrence, UTC by default
+	TimeZone string
+	// Sizes set by the action, unset ones are left unchanged
+	MinSize     *int
+	DesiredSize *int
+	MaxSize     *int
+}
+
+// validateScheduledScaling checks the scheduled actions, keyed by node group name, before creating anything
+func validateScheduledScaling(EksConfig *EksConfig) error {
+	nodeGroupNames := map[string]bool{}
+	for key := range EksConfig.LinuxNodegroups {
+		nodeGroupNames[EksConfig.LinuxNodegroups[key]["name"]] = true
+	}
+	for key := range EksConfig.WindowsNodegroups {
+		nodeGroupNames[EksConfig.WindowsNodegroups[key]["name"]] = true
+	}
+
+	for nodeGroupName, scheduledActions := range EksConfig.ScheduledScaling {
+		if !nodeGroupNames[nodeGroupName] {
+			return fmt.Errorf("eks %s: scheduled scaling of unknown node group %s", EksConfig.Name, nodeGroupName)
+		}
+		actionNames := map[string]bool{}
+		for _, scheduledAction := range scheduledActions {
+			if scheduledAction.Name == "" || actionNames[scheduledAction.Name] {
+				return fmt.Errorf("eks %s: scheduled actions of node group %s need unique names", EksConfig.Name, nodeGroupName)
+			}
+			actionNames[scheduledAction.Name] = true
+			if scheduledAction.Recurrence == "" {
+				return fmt.Errorf("eks %s: scheduled action %s of node group %s has no recurrence", EksConfig.Name, scheduledAction.Name, nodeGroupName)
+			}
+			if scheduledAction.MinSize == nil && scheduledAction.DesiredSize == nil && scheduledAction.MaxSize == nil {
+				return fmt.Errorf("eks %s: scheduled action %s of node group %s sets no size", EksConfig.Name, scheduledAction.Name, nodeGroupName)
+			}
+			sizes := []*int{scheduledAction.MinSize, scheduledAction.DesiredSize, scheduledAction.MaxSize}
+			for index, size := range sizes {
+				if size == nil {
+					continue
+				}
+				if *size < 0 {
+					return fmt.Errorf("eks %s: scheduled action %s of node group %s has a negative size", EksConfig.Name, scheduledAction.Name, nodeGroupName)
+				}
+				for _, nextSize := range sizes[index+1:] {
+					if nextSize != nil && *nextSize < *size {
+						return fmt.Errorf("eks %s: scheduled action %s of node group %s needs minSize <= desiredSize <= maxSize", EksConfig.Name, scheduledAction.Name, nodeGroupName)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// scheduledSize returns a size of a scheduled action for one of the groups of the node group, -1 leaving the size
+// of the group unchanged
+func scheduledSize(size *int, placements int) pulumi.IntPtrInput {
+	if size == nil {
+		return pulumi.Int(-1)
+	}
+	return pulumi.Int(placementSize(*size, placements))
+}
+
+// createScheduledActions creates the scheduled actions of a node group on one of its ASGs, named after the ASG,
+// with the sizes split across the groups of the node group
+func createScheduledActions(ctx *pulumi.Context, nodeGroupName string, scheduledActions []ScheduledActionConfig, placements int, autoScalingGroupName pulumi.StringInput) error {
+	for _, scheduledAction := range scheduledActions {
+		timeZone := scheduledAction.TimeZone
+		if timeZone == "" {
+			timeZone = "Etc/UTC"
+		}
+		_, err := autoscaling.NewSchedule(ctx, nodeGroupName+"-schedule-"+scheduledAction.Name, &autoscaling.ScheduleArgs{
+			ScheduledActionName:  pulumi.String(scheduledAction.Name),
+			AutoscalingGroupName: autoScalingGroupName,
+			Recurrence:           pulumi.String(scheduledAction.Recurrence),
+			TimeZone:             pulumi.String(timeZone),
+			MinSize:              scheduledSize(scheduledAction.MinSize, placements),
+			DesiredCapacity:      scheduledSize(scheduledAction.DesiredSize, placements),
+			MaxSize:              scheduledSize(scheduledAction.MaxSize, placements),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	awseks "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/sqs"
+	"github.com/pulumi/pulumi-eks/sdk/go/eks"
+	"github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes"
+	helmv3 "github.com/pulumi/pulumi-kubernetes/sdk/v3/go/kubernetes/helm/v3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+type TerminationHandlerConfig struct {
+	Enabled bool
+	// Version of the aws-node-termination-handler chart, "0.21.0" by default
+	ChartVersion string
+	// Time the busy runner pods get to finish their jobs before the instance is terminated, 3600 by default
+	DrainTimeoutSeconds int
+}
+
+// Service account of the termination handler, in kube-system
+const terminationHandlerServiceAccount = "aws-node-termination-handler"
+
+// Lifecycle hooks can't wait more than 2 hours without heartbeat, a margin is kept over the drain timeout
+const terminationHookMargin = 300
+
+// drainTimeout returns the time given to the runner pods to finish, defaulting to one hour
+func drainTimeout(EksConfig *EksConfig) int {
+	if EksConfig.TerminationHandler.DrainTimeoutSeconds == 0 {
+		return 3600
+	}
+	return EksConfig.TerminationHandler.DrainTimeoutSeconds
+}
+
+// validateTerminationHandler checks the drain timeout fits in a lifecycle hook, and that no drained node goes back
+// to a warm pool: the handler cordons it and never uncordons it, so it would come back in service unschedulable
+func validateTerminationHandler(EksConfig *EksConfig) error {
+	if !EksConfig.TerminationHandler.Enabled {
+		return nil
+	}
+	if timeout := drainTimeout(EksConfig); timeout < 0 || timeout+terminationHookMargin > 7200 {
+		return fmt.Errorf("eks %s: terminationHandler drainTimeoutSeconds must be between 0 and %d", EksConfig.Name, 7200-terminationHookMargin)
+	}
+	for key := range EksConfig.WindowsNodegroups {
+		nodeGroup := EksConfig.WindowsNodegroups[key]
+		if reuseOnScaleIn, _ := strconv.ParseBool(nodeGroup["warmPoolReuseOnScaleIn"]); reuseOnScaleIn {
+			return fmt.Errorf("eks %s: node group %s: warmPoolReuseOnScaleIn can't be used with the termination handler, the drained nodes would re-enter service cordoned", EksConfig.Name, nodeGroup["name"])
+		}
+	}
+	return nil
+}
+
+// managedNodeGroupAsgName returns the name of the ASG that EKS creates for a managed node group
+func managedNodeGroupAsgName(nodeGroup *awseks.NodeGroup) pulumi.StringOutput {
+	return nodeGroup.Resources.Index(pulumi.Int(0)).AutoscalingGroups().Index(pulumi.Int(0)).Name().Elem()
+}
+
+// createTerminationHook holds the terminating instances of a node group until the termination handler
+// has drained them, or continues the termination when it doesn't answer in time
+func createTerminationHook(ctx *pulumi.Context, EksConfig *EksConfig, nodeGroupName string, autoScalingGroupName pulumi.StringInput) error {
+	if !EksConfig.TerminationHandler.Enabled {
+		return nil
+	}
+	_, err := autoscaling.NewLifecycleHook(ctx, nodeGroupName+"-termination-hook", &autoscaling.LifecycleHookArgs{
+		Name:                 pulumi.String(nodeGroupName + "-termination-hook"),
+		AutoscalingGroupName: autoScalingGroupName,
+		LifecycleTransition:  pulumi.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+		DefaultResult:        pulumi.String("CONTINUE"),
+		HeartbeatTimeout:     pulumi.Int(drainTimeout(EksConfig) + terminationHookMargin),
+	})
+	return err
+}
+
+// createTerminationHandler makes the node terminations graceful. The events of the termination lifecycle hooks,
+// the spot interruptions and the rebalance recommendations go to an SQS queue, read by aws-node-termination-handler
+// (queue mode, IRSA) that cordons and drains the nodes. The runner pods get up to the drain timeout to finish
+// their jobs before the lifecycle action is completed.
+func createTerminationHandler(ctx *pulumi.Context, EksConfig *EksConfig, CommonTags pulumi.StringMap, eksCluster *eks.Cluster, k8sProvider *kubernetes.Provider, linuxNodeGroups []*awseks.NodeGroup, windowsNodeGroups []*autoscaling.Group) error {
+	if !EksConfig.TerminationHandler.Enabled {
+		return nil
+	}
+	conf := config.New(ctx, "")
+	chartVersion := EksConfig.TerminationHandler.ChartVersion
+	if chartVersion == "" {
+		chartVersion = "0.21.0"
+	}
+	timeout := drainTimeout(EksConfig)
+
+	autoScalingGroupNames := pulumi.StringArray{}
+	for _, nodeGroup := range windowsNodeGroups {
+		autoScalingGroupNames = append(autoScalingGroupNames, nodeGroup.Name)
+	}
+	for _, nodeGroup := range linuxNodeGroups {
+		autoScalingGroupNames = append(autoScalingGroupNames, managedNodeGroupAsgName(nodeGroup))
+	}
+
+	queue, err := sqs.NewQueue(ctx, EksConfig.Name+"-termination-queue", &sqs.QueueArgs{
+		Name:                    pulumi.String(EksConfig.Name + "-termination-queue"),
+		MessageRetentionSeconds: pulumi.Int(300),
+		SqsManagedSseEnabled:    pulumi.Bool(true),
+		Tags:                    pulumi.StringMap(CommonTags),
+	})
+	if err != nil {
+		return err
+	}
+
+	queuePolicyJson := queue.Arn.ApplyT(func(queueArn string) (string, error) {
+		queuePolicyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Action": "sqs:SendMessage",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Service": []string{"events.amazonaws.com", "sqs.amazonaws.com"},
+					},
+					"Resource": queueArn,
+				},
+			},
+		})
+		return string(queuePolicyJson), err
+	}).(pulumi.StringOutput)
+
+	_, err = sqs.NewQueuePolicy(ctx, EksConfig.Name+"-termination-queue-policy", &sqs.QueuePolicyArgs{
+		QueueUrl: queue.Url,
+		Policy:   queuePolicyJson,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The lifecycle events are limited to the node groups of the cluster, the EC2 events are filtered by the handler
+	lifecycleEventPattern := autoScalingGroupNames.ToStringArrayOutput().ApplyT(func(names []string) (string, error) {
+		sort.Strings(names)
+		eventPatternJson, err := json.Marshal(map[string]interface{}{
+			"source":      []string{"aws.autoscaling"},
+			"detail-type": []string{"EC2 Instance-terminate Lifecycle Action"},
+			"detail": map[string]interface{}{
+				"AutoScalingGroupName": names,
+			},
+		})
+		return string(eventPatternJson), err
+	}).(pulumi.StringOutput)
+
+	eventRules := map[string]pulumi.StringInput{
+		"asg-lifecycle":         lifecycleEventPattern,
+		"spot-interruption":     pulumi.String(`{"source": ["aws.ec2"], "detail-type": ["EC2 Spot Instance Interruption Warning"]}`),
+		"rebalance":             pulumi.String(`{"source": ["aws.ec2"], "detail-type": ["EC2 Instance Rebalance Recommendation"]}`),
+		"instance-state-change": pulumi.String(`{"source": ["aws.ec2"], "detail-type": ["EC2 Instance State-change Notification"]}`),
+	}
+	for _, ruleName := range []string{"asg-lifecycle", "spot-interruption", "rebalance", "instance-state-change"} {
+		eventRule, err := cloudwatch.NewEventRule(ctx, EksConfig.Name+"-termination-"+ruleName, &cloudwatch.EventRuleArgs{
+			Name:         pulumi.String(EksConfig.Name + "-termination-" + ruleName),
+			Description:  pulumi.String("Node termination events of " + EksConfig.Name + " EKS cluster"),
+			EventPattern: eventRules[ruleName],
+			Tags:         pulumi.StringMap(CommonTags),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = cloudwatch.NewEventTarget(ctx, EksConfig.Name+"-termination-"+ruleName, &cloudwatch.EventTargetArgs{
+			Rule: eventRule.Name,
+			Arn:  queue.Arn,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	terminationHandlerRole, err := NewIrsaRole(ctx, EksConfig.Name+"-termination-handler-role", eksCluster.EksCluster, "kube-system", terminationHandlerServiceAccount, nil, CommonTags)
+	if err != nil {
+		return err
+	}
+
+	terminationHandlerPolicyJson := queue.Arn.ApplyT(func(queueArn string) (string, error) {
+		policyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Action": []string{
+						"autoscaling:CompleteLifecycleAction",
+						"autoscaling:DescribeAutoScalingInstances",
+						"autoscaling:DescribeTags",
+						"ec2:DescribeInstances",
+					},
+					"Effect":   "Allow",
+					"Resource": "*",
+				},
+				map[string]interface{}{
+					"Action": []string{
+						"sqs:DeleteMessage",
+						"sqs:ReceiveMessage",
+					},
+					"Effect":   "Allow",
+					"Resource": queueArn,
+				},
+			},
+		})
+		return string(policyJson), err
+	}).(pulumi.StringOutput)
+
+	_, err = iam.NewRolePolicy(ctx, EksConfig.Name+"-termination-handler-policy", &iam.RolePolicyArgs{
+		Role:   terminationHandlerRole.Name,
+		Policy: terminationHandlerPolicyJson,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = helmv3.NewRelease(ctx, EksConfig.Name+"-termination-handler", &helmv3.ReleaseArgs{
+		Name:      pulumi.String("aws-node-termination-handler"),
+		Chart:     pulumi.String("aws-node-termination-handler"),
+		Version:   pulumi.String(chartVersion),
+		Namespace: pulumi.String("kube-system"),
+		RepositoryOpts: &helmv3.RepositoryOptsArgs{
+			Repo: pulumi.String("https://aws.github.io/eks-charts"),
+		},
+		Values: pulumi.Map{
+			"enableSqsTerminationDraining": pulumi.Bool(true),
+			"queueURL":                     queue.Url,
+			"awsRegion":                    pulumi.String(conf.Require("region")),
+			// Every event in the queue is for the cluster, the node groups don't carry the managed tag
+			"checkASGTagBeforeDraining":  pulumi.Bool(false),
+			"checkTagBeforeDraining":     pulumi.Bool(false),
+			"deleteSqsMsgIfNodeNotFound": pulumi.Bool(true),
+			// The runner pods keep their own grace period, the whole drain is bounded by the timeout
+			"podTerminationGracePeriod":  pulumi.Int(-1),
+			"nodeTerminationGracePeriod": pulumi.Int(timeout),
+			"serviceAccount": pulumi.Map{
+				"name": pulumi.String(terminationHandlerServiceAccount),
+				"annotations": pulumi.Map{
+					"eks.amazonaws.com/role-arn": terminationHandlerRole.Arn,
+				},
+			},
+			"nodeSelector": pulumi.Map{
+				"kubernetes.io/os": pulumi.String("linux"),
+			},
+		},
+	}, pulumi.Provider(k8sProvider))
+	if err != nil {
+		return err
+	}
+
+	ctx.Export("termination-queue-url", queue.Url)
+	return nil
+}
@@ -0,0 +1,108 @@
+package eks
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	awseks "github.com/pulumi/pulumi-aws/sdk/v5/go/aws/eks"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// planUpgrade compares the configured Kubernetes version with the one of the running cluster.
+// It returns the running version when the cluster has to be upgraded, and an empty string for new clusters
+// and clusters already on the configured version. Upgrades go one minor version at a time, skips and downgrades are refused.
+func planUpgrade(ctx *pulumi.Context, EksConfig *EksConfig) (string, error) {
+	// The cluster is new when it isn't listed, any other lookup failure stops the deployment
+	clusters, err := awseks.GetClusters(ctx)
+	if err != nil {
+		return "", fmt.Errorf("eks %s: can't list the clusters to check the running version: %w", EksConfig.Name, err)
+	}
+	if !containsClusterName(clusters.Names, EksConfig.Name) {
+		return "", nil
+	}
+	runningCluster, err := awseks.LookupCluster(ctx, &awseks.LookupClusterArgs{
+		Name: EksConfig.Name,
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("eks %s: can't read the running version: %w", EksConfig.Name, err)
+	}
+
+	if runningCluster.Version == EksConfig.Version {
+		return "", nil
+	}
+
+	runningMajor, runningMinor, err := parseKubernetesVersion(runningCluster.Version)
+	if err != nil {
+		return "", err
+	}
+	major, minor, err := parseKubernetesVersion(EksConfig.Version)
+	if err != nil {
+		return "", err
+	}
+	if major != runningMajor || minor < runningMinor {
+		return "", fmt.Errorf("eks %s: can't move from Kubernetes %s to %s, only upgrades are supported", EksConfig.Name, runningCluster.Version, EksConfig.Version)
+	}
+	if minor > runningMinor+1 {
+		return "", fmt.Errorf("eks %s: can't upgrade from Kubernetes %s to %s, upgrade one minor version at a time (next is %d.%d)", EksConfig.Name, runningCluster.Version, EksConfig.Version, runningMajor, runningMinor+1)
+	}
+	return runningCluster.Version, nil
+}
+
+// containsClusterName tells if the cluster is in the listed names
+func containsClusterName(names []string, name string) bool {
+	for _, listedName := range names {
+		if listedName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKubernetesVersion splits a major.minor version
+func parseKubernetesVersion(version string) (int, int, error) {
+	fields := strings.Split(version, ".")
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q, must be major.minor", version)
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q, must be major.minor", version)
+	}
+	minor, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid Kubernetes version %q, must be major.minor", version)
+	}
+	return major, minor, nil
+}
+
+// logUpgradePlan reports, during the preview, the order in which the upgrade is going to be applied
+func logUpgradePlan(ctx *pulumi.Context, EksConfig *EksConfig, runningVersion string, addonVersions map[string]string) {
+	if !ctx.DryRun() {
+		return
+	}
+
+	addons := []string{}
+	for name, version := range addonVersions {
+		addons = append(addons, name+" "+version)
+	}
+	sort.Strings(addons)
+	linuxNodeGroups := []string{}
+	for key := range EksConfig.LinuxNodegroups {
+		linuxNodeGroups = append(linuxNodeGroups, EksConfig.LinuxNodegroups[key]["name"])
+	}
+	sort.Strings(linuxNodeGroups)
+	windowsNodeGroups := []string{}
+	for key := range EksConfig.WindowsNodegroups {
+		windowsNodeGroups = append(windowsNodeGroups, EksConfig.WindowsNodegroups[key]["name"])
+	}
+	sort.Strings(windowsNodeGroups)
+
+	plan := fmt.Sprintf("eks %s: upgrade from Kubernetes %s to %s\n", EksConfig.Name, runningVersion, EksConfig.Version)
+	plan += fmt.Sprintf("  1. control plane %s\n", EksConfig.Version)
+	plan += fmt.Sprintf("  2. add-ons: %s\n", strings.Join(addons, ", "))
+	plan += fmt.Sprintf("  3. Linux node groups: %s\n", strings.Join(linuxNodeGroups, ", "))
+	plan += fmt.Sprintf("  4. Windows node groups instance refresh: %s", strings.Join(windowsNodeGroups, ", "))
+	_ = ctx.Log.Info(plan, nil)
+}
@@ -0,0 +1,138 @@
+package eks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Time a Windows instance has to bootstrap, or to get ready in the warm pool, before it's abandoned
+const windowsLaunchHookTimeout = 1800
+
+// windowsLaunchHookName returns the name of the launch lifecycle hook of a Windows node group, empty without warm pool
+func windowsLaunchHookName(nodeGroup map[string]string) string {
+	if nodeGroup["warmPoolState"] == "" {
+		return ""
+	}
+	return nodeGroup["name"] + "-launch-hook"
+}
+
+// validateWarmPools checks the warm pool settings of the Windows node groups
+func validateWarmPools(EksConfig *EksConfig) error {
+	for key := range EksConfig.WindowsNodegroups {
+		nodeGroup := EksConfig.WindowsNodegroups[key]
+		if _, err := windowsWarmPool(nodeGroup); err != nil {
+			return fmt.Errorf("eks %s: node group %s: %v", EksConfig.Name, nodeGroup["name"], err)
+		}
+	}
+	return nil
+}
+
+// windowsWarmPool returns the warm pool of a Windows node group, from its "warmPool*" keys. Nil without "warmPoolState"
+func windowsWarmPool(nodeGroup map[string]string) (autoscaling.GroupWarmPoolPtrInput, error) {
+	switch nodeGroup["warmPoolState"] {
+	case "":
+		return nil, nil
+	case "Stopped", "Hibernated":
+	default:
+		return nil, fmt.Errorf("unknown warmPoolState %q, must be Stopped or Hibernated", nodeGroup["warmPoolState"])
+	}
+
+	warmPool := &autoscaling.GroupWarmPoolArgs{
+		PoolState: pulumi.String(nodeGroup["warmPoolState"]),
+	}
+	if nodeGroup["warmPoolMinSize"] != "" {
+		minSize, err := strconv.Atoi(nodeGroup["warmPoolMinSize"])
+		if err != nil {
+			return nil, err
+		}
+		warmPool.MinSize = pulumi.Int(minSize)
+	}
+	// Defaults to the max size of the group
+	if nodeGroup["warmPoolMaxPreparedCapacity"] != "" {
+		maxPreparedCapacity, err := strconv.Atoi(nodeGroup["warmPoolMaxPreparedCapacity"])
+		if err != nil {
+			return nil, err
+		}
+		warmPool.MaxGroupPreparedCapacity = pulumi.Int(maxPreparedCapacity)
+	}
+	if nodeGroup["warmPoolReuseOnScaleIn"] != "" {
+		reuseOnScaleIn, err := strconv.ParseBool(nodeGroup["warmPoolReuseOnScaleIn"])
+		if err != nil {
+			return nil, err
+		}
+		warmPool.InstanceReusePolicy = &autoscaling.GroupWarmPoolInstanceReusePolicyArgs{
+			ReuseOnScaleIn: pulumi.Bool(reuseOnScaleIn),
+		}
+	}
+	return warmPool, nil
+}
+
+// windowsInitialLifecycleHooks returns the launch lifecycle hook of a Windows node group with a warm pool, created
+// along with its ASGs so it holds the very first instances. Empty without warm pool.
+func windowsInitialLifecycleHooks(nodeGroup map[string]string) autoscaling.GroupInitialLifecycleHookArrayInput {
+	launchHookName := windowsLaunchHookName(nodeGroup)
+	if launchHookName == "" {
+		return nil
+	}
+	return autoscaling.GroupInitialLifecycleHookArray{
+		&autoscaling.GroupInitialLifecycleHookArgs{
+			Name:                pulumi.String(launchHookName),
+			LifecycleTransition: pulumi.String("autoscaling:EC2_INSTANCE_LAUNCHING"),
+			DefaultResult:       pulumi.String("ABANDON"),
+			HeartbeatTimeout:    pulumi.Int(windowsLaunchHookTimeout),
+		},
+	}
+}
+
+// createWindowsLaunchHook manages the launch lifecycle hook of a Windows node group with a warm pool, on one of its ASGs.
+// The instances are held until their user data completes it: once ready in the warm pool, or once joined to the cluster
+// when they enter service. The initial hook of the ASG only exists for the groups created with it, this resource
+// (an upsert of the same hook) adds it to the existing groups and applies its changes.
+func createWindowsLaunchHook(ctx *pulumi.Context, nodeGroup map[string]string, autoScalingGroupName string, autoscalingGroup *autoscaling.Group) error {
+	_, err := autoscaling.NewLifecycleHook(ctx, autoScalingGroupName+"-launch-hook", &autoscaling.LifecycleHookArgs{
+		Name:                 pulumi.String(windowsLaunchHookName(nodeGroup)),
+		AutoscalingGroupName: autoscalingGroup.Name,
+		LifecycleTransition:  pulumi.String("autoscaling:EC2_INSTANCE_LAUNCHING"),
+		DefaultResult:        pulumi.String("ABANDON"),
+		HeartbeatTimeout:     pulumi.Int(windowsLaunchHookTimeout),
+	})
+	return err
+}
+
+// createWindowsLaunchHookPolicy lets the instances of a Windows node group complete the launch hook of their ASG,
+// found from their instance ID since the groups placed per AZ share the launch template
+func createWindowsLaunchHookPolicy(ctx *pulumi.Context, nodeGroup map[string]string, nodeGroupRole *iam.Role) error {
+	launchHookPolicyJson, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			map[string]interface{}{
+				"Action": []string{
+					"autoscaling:CompleteLifecycleAction",
+				},
+				"Effect":   "Allow",
+				"Resource": "arn:aws:autoscaling:*:*:autoScalingGroup:*:autoScalingGroupName/" + nodeGroup["name"] + "*",
+			},
+			map[string]interface{}{
+				"Action": []string{
+					"autoscaling:DescribeAutoScalingInstances",
+				},
+				"Effect":   "Allow",
+				"Resource": "*",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = iam.NewRolePolicy(ctx, nodeGroup["name"]+"-launch-hook-policy", &iam.RolePolicyArgs{
+		Role:   nodeGroupRole.Name,
+		Policy: pulumi.String(launchHookPolicyJson),
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+package eks
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/voltrondata/pulumi-go-modules/AWS/imagebuilder"
+)
+
+// Registry and region of an ECR image URI
+var ecrImage = regexp.MustCompile(`^[0-9]+\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com/`)
+
+// prePullImages returns the images listed in the "prePullImages" key (comma separated) of a Windows node group
+func prePullImages(nodeGroup map[string]string) []string {
+	images := []string{}
+	for _, image := range strings.Split(nodeGroup["prePullImages"], ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+// validatePrePull checks the pre-pull settings of the Windows node groups
+func validatePrePull(EksConfig *EksConfig) error {
+	for key := range EksConfig.WindowsNodegroups {
+		nodeGroup := EksConfig.WindowsNodegroups[key]
+		switch nodeGroup["prePullMode"] {
+		case "", "bootstrap":
+		case "bake":
+			if len(prePullImages(nodeGroup)) == 0 {
+				return fmt.Errorf("eks %s: node group %s bakes an AMI but has no prePullImages", EksConfig.Name, nodeGroup["name"])
+			}
+		default:
+			return fmt.Errorf("eks %s: unknown prePullMode %q for node group %s, must be bootstrap or bake", EksConfig.Name, nodeGroup["prePullMode"], nodeGroup["name"])
+		}
+	}
+	return nil
+}
+
+// generatePullImagesScript renders the PowerShell that pulls the images into the k8s.io namespace of containerd,
+// where the kubelet finds them. ECR images are pulled with a token of the instance role. A failed pull doesn't
+// stop the script, the kubelet pulls the image again when a pod needs it.
+func generatePullImagesScript(images []string) string {
+	script := `[string]$ctr = "$env:ProgramFiles\containerd\ctr.exe"
+Start-Service containerd -ErrorAction SilentlyContinue
+`
+	for _, image := range images {
+		pullCommand := fmt.Sprintf(`& $ctr -n k8s.io images pull "%s"`, image)
+		if match := ecrImage.FindStringSubmatch(image); match != nil {
+			pullCommand = fmt.Sprintf(`& $ctr -n k8s.io images pull --user "AWS:$((Get-ECRLoginCommand -Region %s).Password)" "%s"`, match[1], image)
+		}
+		// ctr failing doesn't throw, only the ECR token lookup does, hence the exit code check
+		script += fmt.Sprintf("try { %s; if ($LASTEXITCODE -ne 0) { Write-Output \"Failed to pre-pull %s: ctr exited with $LASTEXITCODE\" } } catch { Write-Output \"Failed to pre-pull %s: $_\" }\n", pullCommand, image, image)
+	}
+	return script
+}
+
+// createWindowsBakedImage bakes, with the imagebuilder module, an AMI on top of the EKS optimized Windows AMI
+// with the images of the node group already pulled, and returns its ID. Any change of the images or of the
+// parent AMI bakes a new AMI and rolls the node group.
+func createWindowsBakedImage(ctx *pulumi.Context, CommonTags pulumi.StringMap, nodeGroup map[string]string, parentImage string, region string, vpc *ec2.Vpc, subnet *ec2.Subnet) (pulumi.StringOutput, error) {
+	name := nodeGroup["name"]
+	builder, err := imagebuilder.NewBuilder(ctx, name, vpc, region, CommonTags)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+	amiId, err := builder.BakeImage(ctx, imagebuilder.ImageBuild{
+		Name:        name + "-pre-pull",
+		ParentImage: parentImage,
+		Os:          "windows",
+		Commands:    strings.Split(strings.TrimSpace(generatePullImagesScript(prePullImages(nodeGroup))), "\n"),
+		SubnetId:    subnet.ID(),
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	ctx.Export(name+"-baked-ami-id", amiId)
+	return amiId, nil
+}
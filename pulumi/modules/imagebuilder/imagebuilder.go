@@ -0,0 +1,497 @@
+package imagebuilder
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/imagebuilder"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
+)
+
+type ImageBuilderConfig struct {
+	Enabled bool
+	Name    string
+	// Kubernetes version of the EKS optimized base AMIs, usually the Version of the EKS cluster
+	KubernetesVersion string
+	Schedule          ScheduleConfig
+	Images            map[string]ImageConfig
+	Tags              map[string]string
+}
+
+type ScheduleConfig struct {
+	// Image Builder cron expression, e.g. "cron(0 4 ? * sun *)". The pipelines only run on demand when empty
+	Expression string
+	// IANA timezone of the expression, UTC by default
+	Timezone string
+	// Only build when the base AMI or a component has a new version, instead of on every match
+	OnlyWithUpdates bool
+}
+
+type ImageConfig struct {
+	// al2, al2023, bottlerocket or windows
+	Os string
+	// x86_64 (default) or arm64
+	Architecture string
+	// Commands installing the tooling, run by bash (or PowerShell on Windows) in one build step
+	Commands []string
+	// ARNs of extra components, e.g. the AWS managed ones
+	Components []string
+	// Size of the root volume, 30 GB by default (50 on Windows)
+	VolumeSize   int
+	InstanceType string
+}
+
+type ImageBuilderOutput struct {
+	Pipelines map[string]*imagebuilder.ImagePipeline
+	// Last AMI built by each pipeline, or the current base AMI for bottlerocket. Empty until the first build
+	AmiIds map[string]string
+}
+
+func errorHandler(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Tag set by the distribution on the AMIs, used to find the last build of a pipeline
+const pipelineTag = "imagebuilder-pipeline"
+
+// baseImageParameter returns the SSM parameter holding the current EKS optimized AMI of an image
+func baseImageParameter(image ImageConfig, kubernetesVersion string) (string, error) {
+	architecture := image.Architecture
+	if architecture == "" {
+		architecture = "x86_64"
+	}
+	if architecture != "x86_64" && architecture != "arm64" {
+		return "", fmt.Errorf("unknown architecture %q, must be x86_64 or arm64", architecture)
+	}
+
+	switch image.Os {
+	case "al2":
+		if architecture == "arm64" {
+			return "/aws/service/eks/optimized-ami/" + kubernetesVersion + "/amazon-linux-2-arm64/recommended/image_id", nil
+		}
+		return "/aws/service/eks/optimized-ami/" + kubernetesVersion + "/amazon-linux-2/recommended/image_id", nil
+	case "al2023":
+		return "/aws/service/eks/optimized-ami/" + kubernetesVersion + "/amazon-linux-2023/" + architecture + "/standard/recommended/image_id", nil
+	case "bottlerocket":
+		return "/aws/service/bottlerocket/aws-k8s-" + kubernetesVersion + "/" + architecture + "/latest/image_id", nil
+	case "windows":
+		if architecture != "x86_64" {
+			return "", fmt.Errorf("windows images are x86_64 only")
+		}
+		return "/aws/service/ami-windows-latest/Windows_Server-2019-English-Core-EKS_Optimized-" + kubernetesVersion + "/image_id", nil
+	}
+	return "", fmt.Errorf("unknown os %q, must be al2, al2023, bottlerocket or windows", image.Os)
+}
+
+// validateImages checks the images before creating anything
+func validateImages(ImageBuilderConfig *ImageBuilderConfig) error {
+	if len(ImageBuilderConfig.Images) > 0 && ImageBuilderConfig.KubernetesVersion == "" {
+		return fmt.Errorf("imagebuilder %s: kubernetesVersion is required", ImageBuilderConfig.Name)
+	}
+	for key, image := range ImageBuilderConfig.Images {
+		if _, err := baseImageParameter(image, ImageBuilderConfig.KubernetesVersion); err != nil {
+			return fmt.Errorf("imagebuilder %s: image %s: %v", ImageBuilderConfig.Name, key, err)
+		}
+		// Bottlerocket has no shell to run components in, its AMIs can't be customized by Image Builder
+		if image.Os == "bottlerocket" && (len(image.Commands) > 0 || len(image.Components) > 0) {
+			return fmt.Errorf("imagebuilder %s: image %s: bottlerocket images can't have commands or components", ImageBuilderConfig.Name, key)
+		}
+	}
+	return nil
+}
+
+// ImageBuild is one AMI built by Image Builder: the parent image and the commands run on top of it
+type ImageBuild struct {
+	// Name of the Image Builder resources and of the AMIs
+	Name string
+	// Parent AMI ID, or "ssm:<parameter name>" to start from the current value of the parameter
+	ParentImage string
+	// al2, al2023 or windows
+	Os string
+	// x86_64 (default) or arm64
+	Architecture string
+	// Commands run by bash (or PowerShell on Windows) in one build step
+	Commands []string
+	// ARNs of extra components, e.g. the AWS managed ones
+	Components []string
+	// Size of the root volume, 30 GB by default (50 on Windows)
+	VolumeSize   int
+	InstanceType string
+	SubnetId     pulumi.StringInput
+	Description  string
+}
+
+// Builder holds what the builds share: the security group, role and instance profile of the build instances
+type Builder struct {
+	name                string
+	region              string
+	securityGroupId     pulumi.IDOutput
+	instanceProfileName pulumi.StringOutput
+	CommonTags          pulumi.StringMap
+}
+
+// generateComponentData renders the Image Builder document running the commands of an image
+func generateComponentData(build ImageBuild) string {
+	action := "ExecuteBash"
+	if build.Os == "windows" {
+		action = "ExecutePowerShell"
+	}
+	componentData := fmt.Sprintf(`name: runner-node-tooling
+description: Install the tooling of the runner nodes
+schemaVersion: 1.0
+phases:
+  - name: build
+    steps:
+      - name: InstallTooling
+        action: %s
+        inputs:
+          commands:
+`, action)
+	for _, command := range build.Commands {
+		componentData += fmt.Sprintf("            - '%s'\n", strings.ReplaceAll(command, "'", "''"))
+	}
+	return componentData
+}
+
+// NewBuilder creates the security group, role and instance profile of the build instances, named after name.
+// The instances can pull ECR images with their role, as the nodes do.
+func NewBuilder(ctx *pulumi.Context, name string, vpc *ec2.Vpc, region string, CommonTags pulumi.StringMap) (*Builder, error) {
+
+	// Build instances only need to reach out, to download the tooling and report to Image Builder
+	buildSg, err := ec2.NewSecurityGroup(ctx, name+"-image-builder-sg", &ec2.SecurityGroupArgs{
+		Name:        pulumi.String(name + "-image-builder-sg"),
+		Description: pulumi.String("Image Builder instances, egress only"),
+		VpcId:       vpc.ID(),
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				Protocol:   pulumi.String("-1"),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Tags: CommonTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buildRole, err := iam.NewRole(ctx, name+"-image-builder-role", &iam.RoleArgs{
+		Name:        pulumi.String(name + "-image-builder-role"),
+		Description: pulumi.String("Role used by the Image Builder instances of " + name),
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Sid": "",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "ec2.amazonaws.com"
+				},
+				"Action": "sts:AssumeRole"
+			}]
+		}`),
+		ManagedPolicyArns: pulumi.StringArray{
+			pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+			pulumi.String("arn:aws:iam::aws:policy/EC2InstanceProfileForImageBuilder"),
+			pulumi.String("arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"),
+		},
+		Tags: CommonTags,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buildInstanceProfile, err := iam.NewInstanceProfile(ctx, name+"-image-builder-instance-profile", &iam.InstanceProfileArgs{
+		Name: pulumi.String(name + "-image-builder-instance-profile"),
+		Role: buildRole.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Builder{
+		name:                name,
+		region:              region,
+		securityGroupId:     buildSg.ID(),
+		instanceProfileName: buildInstanceProfile.Name,
+		CommonTags:          CommonTags,
+	}, nil
+}
+
+// buildConfigurations creates the recipe, infrastructure and distribution configurations of a build, returning their ARNs
+func (builder *Builder) buildConfigurations(ctx *pulumi.Context, build ImageBuild) (pulumi.StringOutput, pulumi.StringOutput, pulumi.StringOutput, error) {
+	platform := "Linux"
+	deviceName := "/dev/xvda"
+	volumeSize := 30
+	instanceType := "m5.large"
+	if build.Os == "windows" {
+		platform = "Windows"
+		deviceName = "/dev/sda1"
+		volumeSize = 50
+		instanceType = "m5.2xlarge"
+	} else if build.Architecture == "arm64" {
+		instanceType = "m6g.large"
+	}
+	if build.VolumeSize != 0 {
+		volumeSize = build.VolumeSize
+	}
+	if build.InstanceType != "" {
+		instanceType = build.InstanceType
+	}
+
+	// Components and recipes are immutable, so they are named after a hash of their content
+	// and any change creates new ones next to the old ones
+	componentArns := pulumi.StringArray{}
+	if len(build.Commands) > 0 {
+		componentData := generateComponentData(build)
+		componentHash := fmt.Sprintf("%x", sha256.Sum256([]byte(componentData)))[:10]
+		component, err := imagebuilder.NewComponent(ctx, build.Name+"-component-"+componentHash, &imagebuilder.ComponentArgs{
+			Name:     pulumi.String(build.Name + "-" + componentHash),
+			Platform: pulumi.String(platform),
+			Version:  pulumi.String("1.0.0"),
+			Data:     pulumi.String(componentData),
+			Tags:     builder.CommonTags,
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, pulumi.StringOutput{}, pulumi.StringOutput{}, err
+		}
+		componentArns = append(componentArns, component.Arn)
+	}
+	for _, componentArn := range build.Components {
+		componentArns = append(componentArns, pulumi.String(componentArn))
+	}
+	if len(componentArns) == 0 {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, pulumi.StringOutput{}, fmt.Errorf("imagebuilder %s: image %s has no commands nor components", builder.name, build.Name)
+	}
+
+	recipeComponents := imagebuilder.ImageRecipeComponentArray{}
+	for _, componentArn := range componentArns {
+		recipeComponents = append(recipeComponents, &imagebuilder.ImageRecipeComponentArgs{
+			ComponentArn: componentArn,
+		})
+	}
+
+	recipeHash := fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s%v%s%d", build.ParentImage, build.Commands, strings.Join(build.Components, ","), volumeSize))))[:10]
+	recipe, err := imagebuilder.NewImageRecipe(ctx, build.Name+"-recipe-"+recipeHash, &imagebuilder.ImageRecipeArgs{
+		Name:        pulumi.String(build.Name + "-" + recipeHash),
+		Version:     pulumi.String("1.0.0"),
+		ParentImage: pulumi.String(build.ParentImage),
+		Components:  recipeComponents,
+		BlockDeviceMappings: imagebuilder.ImageRecipeBlockDeviceMappingArray{
+			&imagebuilder.ImageRecipeBlockDeviceMappingArgs{
+				DeviceName: pulumi.String(deviceName),
+				Ebs: &imagebuilder.ImageRecipeBlockDeviceMappingEbsArgs{
+					VolumeSize:          pulumi.Int(volumeSize),
+					VolumeType:          pulumi.String("gp3"),
+					DeleteOnTermination: pulumi.String("true"),
+				},
+			},
+		},
+		Tags: builder.CommonTags,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, pulumi.StringOutput{}, err
+	}
+
+	infrastructureConfiguration, err := imagebuilder.NewInfrastructureConfiguration(ctx, build.Name+"-infrastructure", &imagebuilder.InfrastructureConfigurationArgs{
+		Name:                       pulumi.String(build.Name),
+		InstanceProfileName:        builder.instanceProfileName,
+		InstanceTypes:              pulumi.StringArray{pulumi.String(instanceType)},
+		SubnetId:                   build.SubnetId,
+		SecurityGroupIds:           pulumi.StringArray{builder.securityGroupId},
+		TerminateInstanceOnFailure: pulumi.Bool(true),
+		Tags:                       builder.CommonTags,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, pulumi.StringOutput{}, err
+	}
+
+	amiTags := pulumi.StringMap{}
+	for index, tag := range builder.CommonTags {
+		amiTags[index] = tag
+	}
+	amiTags["Name"] = pulumi.String(build.Name)
+	amiTags[pipelineTag] = pulumi.String(build.Name)
+	distributionConfiguration, err := imagebuilder.NewDistributionConfiguration(ctx, build.Name+"-distribution", &imagebuilder.DistributionConfigurationArgs{
+		Name: pulumi.String(build.Name),
+		Distributions: imagebuilder.DistributionConfigurationDistributionArray{
+			&imagebuilder.DistributionConfigurationDistributionArgs{
+				Region: pulumi.String(builder.region),
+				AmiDistributionConfiguration: &imagebuilder.DistributionConfigurationDistributionAmiDistributionConfigurationArgs{
+					Name:    pulumi.String(build.Name + "-{{ imagebuilder:buildDate }}"),
+					AmiTags: amiTags,
+				},
+			},
+		},
+		Tags: builder.CommonTags,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, pulumi.StringOutput{}, err
+	}
+
+	return recipe.Arn, infrastructureConfiguration.Arn, distributionConfiguration.Arn, nil
+}
+
+// NewImagePipeline creates the pipeline of a build, run on the schedule (or on demand) outside of Pulumi
+func (builder *Builder) NewImagePipeline(ctx *pulumi.Context, build ImageBuild, schedule ScheduleConfig) (*imagebuilder.ImagePipeline, error) {
+	recipeArn, infrastructureConfigurationArn, distributionConfigurationArn, err := builder.buildConfigurations(ctx, build)
+	if err != nil {
+		return nil, err
+	}
+
+	pipelineArgs := &imagebuilder.ImagePipelineArgs{
+		Name:                           pulumi.String(build.Name),
+		Description:                    pulumi.String(build.Description),
+		ImageRecipeArn:                 recipeArn,
+		InfrastructureConfigurationArn: infrastructureConfigurationArn,
+		DistributionConfigurationArn:   distributionConfigurationArn,
+		Tags:                           builder.CommonTags,
+	}
+	if schedule.Expression != "" {
+		startCondition := "EXPRESSION_MATCH_ONLY"
+		if schedule.OnlyWithUpdates {
+			startCondition = "EXPRESSION_MATCH_AND_DEPENDENCY_UPDATES_AVAILABLE"
+		}
+		timezone := schedule.Timezone
+		if timezone == "" {
+			timezone = "Etc/UTC"
+		}
+		pipelineArgs.Schedule = &imagebuilder.ImagePipelineScheduleArgs{
+			ScheduleExpression:              pulumi.String(schedule.Expression),
+			Timezone:                        pulumi.String(timezone),
+			PipelineExecutionStartCondition: pulumi.String(startCondition),
+		}
+	}
+	return imagebuilder.NewImagePipeline(ctx, build.Name+"-pipeline", pipelineArgs)
+}
+
+// BakeImage builds the image during the update and returns its AMI ID. A change of the parent image or of the
+// commands creates a new recipe, which replaces the image and builds it again.
+func (builder *Builder) BakeImage(ctx *pulumi.Context, build ImageBuild) (pulumi.StringOutput, error) {
+	recipeArn, infrastructureConfigurationArn, distributionConfigurationArn, err := builder.buildConfigurations(ctx, build)
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	// Takes a while for Windows
+	image, err := imagebuilder.NewImage(ctx, build.Name+"-image", &imagebuilder.ImageArgs{
+		ImageRecipeArn:                 recipeArn,
+		InfrastructureConfigurationArn: infrastructureConfigurationArn,
+		DistributionConfigurationArn:   distributionConfigurationArn,
+		Tags:                           builder.CommonTags,
+	}, pulumi.Timeouts(&pulumi.CustomTimeouts{Create: "3h"}))
+	if err != nil {
+		return pulumi.StringOutput{}, err
+	}
+
+	amiId := image.OutputResources.ApplyT(func(outputResources []imagebuilder.ImageOutputResource) (string, error) {
+		if len(outputResources) == 0 || len(outputResources[0].Amis) == 0 || outputResources[0].Amis[0].Image == nil {
+			return "", fmt.Errorf("imagebuilder %s: Image Builder didn't return an AMI for %s", builder.name, build.Name)
+		}
+		return *outputResources[0].Amis[0].Image, nil
+	}).(pulumi.StringOutput)
+	return amiId, nil
+}
+
+// CreateImagePipelines creates an EC2 Image Builder pipeline per image of the config, building the EKS optimized
+// AMI of its OS with the tooling on top. The builds run on schedule (or on demand) outside of Pulumi, so the node
+// groups only move to a new AMI when its ID, exported by the next update, is set in their config.
+func CreateImagePipelines(ctx *pulumi.Context, vpc *ec2.Vpc, subnets []*ec2.Subnet) (ImageBuilderOutput, error) {
+
+	// Get the Image Builder config from context
+	ImageBuilderConfig := &ImageBuilderConfig{}
+	conf := config.New(ctx, "")
+	conf.GetObject("ImageBuilder", &ImageBuilderConfig)
+
+	ImageBuilderOutput := ImageBuilderOutput{
+		Pipelines: map[string]*imagebuilder.ImagePipeline{},
+		AmiIds:    map[string]string{},
+	}
+	if !ImageBuilderConfig.Enabled {
+		return ImageBuilderOutput, nil
+	}
+	if err := validateImages(ImageBuilderConfig); err != nil {
+		return ImageBuilderOutput, err
+	}
+
+	// Create a pulumiStringMap for the Tags
+	CommonTags := pulumi.StringMap{}
+	for index, tag := range ImageBuilderConfig.Tags {
+		CommonTags[index] = pulumi.String(tag)
+	}
+	name := ImageBuilderConfig.Name
+
+	builder, err := NewBuilder(ctx, name, vpc, conf.Require("region"), CommonTags)
+	errorHandler(err)
+
+	// Sorted, so the subnets are spread the same way on every update
+	keys := []string{}
+	for key := range ImageBuilderConfig.Images {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for index, key := range keys {
+		image := ImageBuilderConfig.Images[key]
+		imageName := name + "-" + key
+		parameterName, _ := baseImageParameter(image, ImageBuilderConfig.KubernetesVersion)
+
+		// Nothing to build for Bottlerocket, the current base AMI is exported so node groups can pin it
+		if image.Os == "bottlerocket" {
+			baseImage, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+				Name: parameterName,
+			}, nil)
+			errorHandler(err)
+			ImageBuilderOutput.AmiIds[key] = baseImage.Value
+			ctx.Export(imageName+"-ami-id", pulumi.String(baseImage.Value))
+			continue
+		}
+
+		// The parent image is the SSM parameter, so every build starts from the current EKS optimized AMI
+		pipeline, err := builder.NewImagePipeline(ctx, ImageBuild{
+			Name:         imageName,
+			ParentImage:  "ssm:" + parameterName,
+			Os:           image.Os,
+			Architecture: image.Architecture,
+			Commands:     image.Commands,
+			Components:   image.Components,
+			VolumeSize:   image.VolumeSize,
+			InstanceType: image.InstanceType,
+			SubnetId:     subnets[index%len(subnets)].ID(),
+			Description:  "EKS optimized " + image.Os + " " + ImageBuilderConfig.KubernetesVersion + " AMI with the runner tooling",
+		}, ImageBuilderConfig.Schedule)
+		if err != nil {
+			return ImageBuilderOutput, err
+		}
+		ImageBuilderOutput.Pipelines[key] = pipeline
+		ctx.Export(imageName+"-image-pipeline-arn", pipeline.Arn)
+
+		// Last AMI distributed by the pipeline, newest first
+		amis, err := ec2.GetAmiIds(ctx, &ec2.GetAmiIdsArgs{
+			Owners: []string{"self"},
+			Filters: []ec2.GetAmiIdsFilter{
+				{
+					Name:   "tag:" + pipelineTag,
+					Values: []string{imageName},
+				},
+			},
+		}, nil)
+		errorHandler(err)
+		amiId := ""
+		if len(amis.Ids) > 0 {
+			amiId = amis.Ids[0]
+		}
+		ImageBuilderOutput.AmiIds[key] = amiId
+		ctx.Export(imageName+"-ami-id", pulumi.String(amiId))
+	}
+
+	return ImageBuilderOutput, nil
+}
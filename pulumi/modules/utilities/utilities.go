@@ -1,9 +1,14 @@
 package utilities
 
 import (
+	"regexp"
+
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
 )
 
+// Graviton instance families (t4g, c6gn, m7gd...) need the arm64 AMI
+var gravitonInstanceType = regexp.MustCompile(`^[a-z]+[0-9]+g`)
+
 func IdOutputArrayToStringOutputArray(as []pulumi.IDOutput) pulumi.StringArray {
 	a := make(pulumi.StringArray, len(as))
 	for i, v := range as {
@@ -11,3 +16,11 @@ func IdOutputArrayToStringOutputArray(as []pulumi.IDOutput) pulumi.StringArray {
 	}
 	return a
 }
+
+// InstanceTypeArchitecture returns the AMI architecture of an instance type, arm64 for Graviton and x86_64 otherwise
+func InstanceTypeArchitecture(instanceType string) string {
+	if gravitonInstanceType.MatchString(instanceType) {
+		return "arm64"
+	}
+	return "x86_64"
+}
@@ -0,0 +1,90 @@
+package vpc
+
+import (
+	"strings"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Interface endpoints created when interfaceEndpoints is not set in the config.
+// It covers what a private EKS cluster needs: image pulls from ECR, IRSA token exchange,
+// node bootstrap, load balancers, autoscaling, logs and SSM sessions on the nodes.
+var defaultInterfaceEndpoints = []string{
+	"ec2",
+	"ecr.api",
+	"ecr.dkr",
+	"sts",
+	"logs",
+	"ssm",
+	"ssmmessages",
+	"ec2messages",
+	"elasticloadbalancing",
+	"autoscaling",
+}
+
+// createInterfaceEndpoints creates one interface endpoint per configured service, with private DNS,
+// placed in one private subnet per AZ (an interface endpoint accepts only one subnet per AZ).
+// All the endpoints share a security group that allows HTTPS from the VPC CIDR block.
+func createInterfaceEndpoints(ctx *pulumi.Context, VpcConfig *VpcConfig, CommonTags pulumi.StringMap, VPC *ec2.Vpc, privateSubnets []*ec2.Subnet, region string) (*ec2.SecurityGroup, []*ec2.VpcEndpoint, error) {
+
+	// A nil list means the key is missing from the config, so the default set is used.
+	// An empty list disables the interface endpoints.
+	services := VpcConfig.InterfaceEndpoints
+	if services == nil {
+		services = defaultInterfaceEndpoints
+	}
+	if len(services) == 0 {
+		return nil, nil, nil
+	}
+
+	sgTags := addNameToCommonTags(VpcConfig.Name+"-vpc-endpoints-sg", CommonTags)
+	endpointsSg, err := ec2.NewSecurityGroup(ctx, "vpc-endpoints-sg", &ec2.SecurityGroupArgs{
+		Name:        pulumi.String(VpcConfig.Name + "-vpc-endpoints-sg"),
+		Description: pulumi.String("VPC interface endpoints, Allow inbound HTTPS from the VPC"),
+		VpcId:       VPC.ID(),
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("tcp"),
+				FromPort:   pulumi.Int(443),
+				ToPort:     pulumi.Int(443),
+				CidrBlocks: vpcCidrBlocks(VpcConfig, VPC),
+			},
+		},
+		Tags: pulumi.StringMap(sgTags),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// First private subnet of every AZ
+	var subnetIds pulumi.StringArray
+	seen := map[string]bool{}
+	for index, availabilityZone := range VpcConfig.PrivateSubnetsAZ {
+		if !seen[availabilityZone] {
+			seen[availabilityZone] = true
+			subnetIds = append(subnetIds, privateSubnets[index].ID().ToStringOutput())
+		}
+	}
+
+	var endpoints []*ec2.VpcEndpoint
+	for _, service := range services {
+		resourceName := strings.ReplaceAll(service, ".", "-") + "-vpc-interface-endpoint"
+		endpointTags := addNameToCommonTags(VpcConfig.Name+"-vpc-"+strings.ReplaceAll(service, ".", "-")+"-endpoint", CommonTags)
+		endpoint, err := ec2.NewVpcEndpoint(ctx, resourceName, &ec2.VpcEndpointArgs{
+			VpcId:             VPC.ID(),
+			ServiceName:       pulumi.String("com.amazonaws." + region + "." + service),
+			VpcEndpointType:   pulumi.String("Interface"),
+			PrivateDnsEnabled: pulumi.Bool(true),
+			SubnetIds:         subnetIds,
+			SecurityGroupIds:  pulumi.StringArray{endpointsSg.ID()},
+			Tags:              pulumi.StringMap(endpointTags),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpointsSg, endpoints, nil
+}
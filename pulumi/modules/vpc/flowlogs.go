@@ -0,0 +1,283 @@
+package vpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/cloudwatch"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/s3"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type FlowLogsConfig struct {
+	Enabled bool
+	// "s3" or "cloud-watch-logs"
+	Destination string
+	// ACCEPT, REJECT or ALL (default)
+	TrafficType string
+	// Custom log format, the AWS default format is used when empty
+	LogFormat string
+	// Days before the logs are expired (S3 lifecycle) or deleted (CloudWatch retention). 0 keeps them forever
+	RetentionDays int
+	// 60 or 600 (default) seconds
+	MaxAggregationInterval int
+}
+
+// validateFlowLogs checks the flow logs settings before anything is created
+func validateFlowLogs(VpcConfig *VpcConfig) error {
+	flowLogsConfig := VpcConfig.FlowLogs
+	if !flowLogsConfig.Enabled {
+		return nil
+	}
+	switch flowLogsConfig.Destination {
+	case "s3", "cloud-watch-logs":
+	default:
+		return fmt.Errorf("vpc %s: unknown flow logs destination %q, must be s3 or cloud-watch-logs", VpcConfig.Name, flowLogsConfig.Destination)
+	}
+	switch flowLogsConfig.TrafficType {
+	case "", "ACCEPT", "REJECT", "ALL":
+	default:
+		return fmt.Errorf("vpc %s: unknown flow logs trafficType %q, must be ACCEPT, REJECT or ALL", VpcConfig.Name, flowLogsConfig.TrafficType)
+	}
+	switch flowLogsConfig.MaxAggregationInterval {
+	case 0, 60, 600:
+	default:
+		return fmt.Errorf("vpc %s: flow logs maxAggregationInterval must be 60 or 600", VpcConfig.Name)
+	}
+	return nil
+}
+
+// createFlowLogs enables the VPC flow logs and creates the destination they are delivered to,
+// together with the bucket policy (S3) or the IAM role (CloudWatch) needed for the delivery.
+// It returns the ARN of the destination.
+func createFlowLogs(ctx *pulumi.Context, VpcConfig *VpcConfig, CommonTags pulumi.StringMap, VPC *ec2.Vpc) (pulumi.StringOutput, error) {
+
+	flowLogsConfig := VpcConfig.FlowLogs
+
+	trafficType := flowLogsConfig.TrafficType
+	if trafficType == "" {
+		trafficType = "ALL"
+	}
+	maxAggregationInterval := flowLogsConfig.MaxAggregationInterval
+	if maxAggregationInterval == 0 {
+		maxAggregationInterval = 600
+	}
+	var logFormat pulumi.StringPtrInput
+	if flowLogsConfig.LogFormat != "" {
+		logFormat = pulumi.String(flowLogsConfig.LogFormat)
+	}
+
+	flowLogTags := addNameToCommonTags(VpcConfig.Name+"-flow-logs", CommonTags)
+
+	switch flowLogsConfig.Destination {
+	case "s3":
+		bucketArn, bucketPolicy, err := createFlowLogsBucket(ctx, VpcConfig, CommonTags)
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		// The bucket policy has to exist before the flow log, otherwise AWS tries to create its own
+		_, err = ec2.NewFlowLog(ctx, "vpc-flow-logs", &ec2.FlowLogArgs{
+			VpcId:                  VPC.ID(),
+			TrafficType:            pulumi.String(trafficType),
+			LogDestinationType:     pulumi.String("s3"),
+			LogDestination:         bucketArn,
+			LogFormat:              logFormat,
+			MaxAggregationInterval: pulumi.Int(maxAggregationInterval),
+			Tags:                   pulumi.StringMap(flowLogTags),
+		}, pulumi.DependsOn([]pulumi.Resource{bucketPolicy}))
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		return bucketArn, nil
+
+	case "cloud-watch-logs":
+		logGroupTags := addNameToCommonTags(VpcConfig.Name+"-flow-logs", CommonTags)
+		logGroup, err := cloudwatch.NewLogGroup(ctx, "vpc-flow-logs-log-group", &cloudwatch.LogGroupArgs{
+			Name:            pulumi.String("/aws/vpc/" + VpcConfig.Name + "/flow-logs"),
+			RetentionInDays: pulumi.Int(flowLogsConfig.RetentionDays),
+			Tags:            pulumi.StringMap(logGroupTags),
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		// Role assumed by the flow logs service to write into the log group
+		flowLogsRole, err := iam.NewRole(ctx, "vpc-flow-logs-role", &iam.RoleArgs{
+			Name:        pulumi.String(VpcConfig.Name + "-vpc-flow-logs-role"),
+			Description: pulumi.String("Role used by the flow logs of " + VpcConfig.Name + " VPC"),
+			AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Sid": "",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "vpc-flow-logs.amazonaws.com"
+				},
+				"Action": "sts:AssumeRole"
+			}]
+		}`),
+			Tags: pulumi.StringMap(CommonTags),
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		flowLogsPolicyJson := logGroup.Arn.ApplyT(func(logGroupArn string) (string, error) {
+			policyJson, err := json.Marshal(map[string]interface{}{
+				"Version": "2012-10-17",
+				"Statement": []map[string]interface{}{
+					map[string]interface{}{
+						"Action": []string{
+							"logs:CreateLogStream",
+							"logs:PutLogEvents",
+							"logs:DescribeLogGroups",
+							"logs:DescribeLogStreams",
+						},
+						"Effect":   "Allow",
+						"Resource": []string{logGroupArn, logGroupArn + ":*"},
+					},
+				},
+			})
+			return string(policyJson), err
+		}).(pulumi.StringOutput)
+
+		_, err = iam.NewRolePolicy(ctx, "vpc-flow-logs-role-policy", &iam.RolePolicyArgs{
+			Role:   flowLogsRole.Name,
+			Policy: flowLogsPolicyJson,
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+
+		_, err = ec2.NewFlowLog(ctx, "vpc-flow-logs", &ec2.FlowLogArgs{
+			VpcId:                  VPC.ID(),
+			TrafficType:            pulumi.String(trafficType),
+			LogDestinationType:     pulumi.String("cloud-watch-logs"),
+			LogDestination:         logGroup.Arn,
+			IamRoleArn:             flowLogsRole.Arn,
+			LogFormat:              logFormat,
+			MaxAggregationInterval: pulumi.Int(maxAggregationInterval),
+			Tags:                   pulumi.StringMap(flowLogTags),
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, err
+		}
+		return logGroup.Arn, nil
+	}
+
+	return pulumi.StringOutput{}, fmt.Errorf("vpc %s: unknown flow logs destination %q, must be s3 or cloud-watch-logs", VpcConfig.Name, flowLogsConfig.Destination)
+}
+
+// createFlowLogsBucket creates a private, encrypted bucket for the flow logs, with a lifecycle rule
+// that expires them after the configured retention, and the policy that allows the log delivery service to write in it.
+func createFlowLogsBucket(ctx *pulumi.Context, VpcConfig *VpcConfig, CommonTags pulumi.StringMap) (pulumi.StringOutput, *s3.BucketPolicy, error) {
+
+	bucketTags := addNameToCommonTags(VpcConfig.Name+"-flow-logs", CommonTags)
+	bucket, err := s3.NewBucketV2(ctx, "vpc-flow-logs-bucket", &s3.BucketV2Args{
+		BucketPrefix: pulumi.String(VpcConfig.Name + "-flow-logs-"),
+		Tags:         pulumi.StringMap(bucketTags),
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, nil, err
+	}
+
+	_, err = s3.NewBucketPublicAccessBlock(ctx, "vpc-flow-logs-bucket-public-access-block", &s3.BucketPublicAccessBlockArgs{
+		Bucket:                bucket.ID(),
+		BlockPublicAcls:       pulumi.Bool(true),
+		BlockPublicPolicy:     pulumi.Bool(true),
+		IgnorePublicAcls:      pulumi.Bool(true),
+		RestrictPublicBuckets: pulumi.Bool(true),
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, nil, err
+	}
+
+	_, err = s3.NewBucketServerSideEncryptionConfigurationV2(ctx, "vpc-flow-logs-bucket-encryption", &s3.BucketServerSideEncryptionConfigurationV2Args{
+		Bucket: bucket.ID(),
+		Rules: s3.BucketServerSideEncryptionConfigurationV2RuleArray{
+			&s3.BucketServerSideEncryptionConfigurationV2RuleArgs{
+				ApplyServerSideEncryptionByDefault: &s3.BucketServerSideEncryptionConfigurationV2RuleApplyServerSideEncryptionByDefaultArgs{
+					SseAlgorithm: pulumi.String("AES256"),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, nil, err
+	}
+
+	if VpcConfig.FlowLogs.RetentionDays > 0 {
+		_, err = s3.NewBucketLifecycleConfigurationV2(ctx, "vpc-flow-logs-bucket-lifecycle", &s3.BucketLifecycleConfigurationV2Args{
+			Bucket: bucket.ID(),
+			Rules: s3.BucketLifecycleConfigurationV2RuleArray{
+				&s3.BucketLifecycleConfigurationV2RuleArgs{
+					Id:     pulumi.String("expire-flow-logs"),
+					Status: pulumi.String("Enabled"),
+					Filter: &s3.BucketLifecycleConfigurationV2RuleFilterArgs{},
+					Expiration: &s3.BucketLifecycleConfigurationV2RuleExpirationArgs{
+						Days: pulumi.Int(VpcConfig.FlowLogs.RetentionDays),
+					},
+				},
+			},
+		})
+		if err != nil {
+			return pulumi.StringOutput{}, nil, err
+		}
+	}
+
+	currentCaller, err := aws.GetCallerIdentity(ctx, nil, nil)
+	if err != nil {
+		return pulumi.StringOutput{}, nil, err
+	}
+
+	bucketPolicyJson := bucket.Arn.ApplyT(func(bucketArn string) (string, error) {
+		policyJson, err := json.Marshal(map[string]interface{}{
+			"Version": "2012-10-17",
+			"Statement": []map[string]interface{}{
+				map[string]interface{}{
+					"Sid":    "AWSLogDeliveryWrite",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Service": "delivery.logs.amazonaws.com",
+					},
+					"Action":   "s3:PutObject",
+					"Resource": bucketArn + "/AWSLogs/" + currentCaller.AccountId + "/*",
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							"s3:x-amz-acl":      "bucket-owner-full-control",
+							"aws:SourceAccount": currentCaller.AccountId,
+						},
+					},
+				},
+				map[string]interface{}{
+					"Sid":    "AWSLogDeliveryAclCheck",
+					"Effect": "Allow",
+					"Principal": map[string]interface{}{
+						"Service": "delivery.logs.amazonaws.com",
+					},
+					"Action":   "s3:GetBucketAcl",
+					"Resource": bucketArn,
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							"aws:SourceAccount": currentCaller.AccountId,
+						},
+					},
+				},
+			},
+		})
+		return string(policyJson), err
+	}).(pulumi.StringOutput)
+
+	bucketPolicy, err := s3.NewBucketPolicy(ctx, "vpc-flow-logs-bucket-policy", &s3.BucketPolicyArgs{
+		Bucket: bucket.ID(),
+		Policy: bucketPolicyJson,
+	})
+	if err != nil {
+		return pulumi.StringOutput{}, nil, err
+	}
+
+	return bucket.Arn, bucketPolicy, nil
+}
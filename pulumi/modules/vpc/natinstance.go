@@ -0,0 +1,247 @@
+package vpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"text/template"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/autoscaling"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ec2"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/iam"
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws/ssm"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/voltrondata/pulumi-go-modules/shared/utilities"
+)
+
+// Instance type used by the NAT instances when natInstanceType is not set
+const defaultNatInstanceType = "t3.nano"
+
+type NatInstanceTemplateInput struct {
+	AwsRegion          string
+	NetworkInterfaceId string
+	MacAddress         string
+}
+
+// createNatInstance creates a NAT instance in the given public subnet, kept alive by an autoscaling group of one.
+// Since the instance is replaced by the ASG, the private route tables don't point to it but to a static network
+// interface (with source/dest check disabled and the EIP associated) that the instance attaches at boot.
+// It returns the ID of that network interface and the public IP used for egress.
+func createNatInstance(ctx *pulumi.Context, VpcConfig *VpcConfig, CommonTags pulumi.StringMap, VPC *ec2.Vpc, subnet *ec2.Subnet, region string, suffix string, index int) (pulumi.IDOutput, pulumi.StringOutput, error) {
+
+	instanceType := VpcConfig.NatInstanceType
+	if instanceType == "" {
+		instanceType = defaultNatInstanceType
+	}
+	architecture := utilities.InstanceTypeArchitecture(instanceType)
+
+	natAMI, err := ssm.LookupParameter(ctx, &ssm.LookupParameterArgs{
+		Name: "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-" + architecture,
+	}, nil)
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	// Security group that allows everything from the VPC to go through the NAT instance
+	sgTags := addNameToCommonTags(VpcConfig.Name+"-nat-instance"+suffix+"-sg", CommonTags)
+	natInstanceSg, err := ec2.NewSecurityGroup(ctx, "nat-instance"+suffix+"-sg", &ec2.SecurityGroupArgs{
+		Name:        pulumi.String(VpcConfig.Name + "-nat-instance" + suffix + "-sg"),
+		Description: pulumi.String("NAT instance, Allow inbound from the VPC"),
+		VpcId:       VPC.ID(),
+		Egress: ec2.SecurityGroupEgressArray{
+			ec2.SecurityGroupEgressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: pulumi.StringArray{pulumi.String("0.0.0.0/0")},
+			},
+		},
+		Ingress: ec2.SecurityGroupIngressArray{
+			ec2.SecurityGroupIngressArgs{
+				Protocol:   pulumi.String("-1"),
+				FromPort:   pulumi.Int(0),
+				ToPort:     pulumi.Int(0),
+				CidrBlocks: vpcCidrBlocks(VpcConfig, VPC),
+			},
+		},
+		Tags: pulumi.StringMap(sgTags),
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	// Static network interface, targeted by the private route tables
+	eniTags := addNameToCommonTags(VpcConfig.Name+"-nat-instance"+suffix+"-eni", CommonTags)
+	natEni, err := ec2.NewNetworkInterface(ctx, "nat-instance"+suffix+"-eni", &ec2.NetworkInterfaceArgs{
+		Description:     pulumi.String("Static network interface of " + VpcConfig.Name + " NAT instance"),
+		SubnetId:        subnet.ID(),
+		SecurityGroups:  pulumi.StringArray{natInstanceSg.ID()},
+		SourceDestCheck: pulumi.Bool(false),
+		Tags:            pulumi.StringMap(eniTags),
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	// Create or adopt the EIP, and keep it on the static network interface
+	allocationId, publicIp, err := natEip(ctx, VpcConfig, CommonTags, suffix, index, -1)
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+	_, err = ec2.NewEipAssociation(ctx, "nat-instance"+suffix+"-eip-assoc", &ec2.EipAssociationArgs{
+		AllocationId:       allocationId,
+		NetworkInterfaceId: natEni.ID(),
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	// The instance needs to attach the static network interface to itself
+	natInstanceRole, err := iam.NewRole(ctx, "nat-instance"+suffix+"-role", &iam.RoleArgs{
+		Name:        pulumi.String(VpcConfig.Name + "-nat-instance" + suffix + "-role"),
+		Description: pulumi.String("Role used by the NAT instance of " + VpcConfig.Name + " VPC"),
+		AssumeRolePolicy: pulumi.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Sid": "",
+				"Effect": "Allow",
+				"Principal": {
+					"Service": "ec2.amazonaws.com"
+				},
+				"Action": "sts:AssumeRole"
+			}]
+		}`),
+		InlinePolicies: iam.RoleInlinePolicyArray{
+			&iam.RoleInlinePolicyArgs{
+				Name: pulumi.String("attach-nat-network-interface"),
+				Policy: pulumi.String(`{
+				"Version": "2012-10-17",
+				"Statement": [{
+					"Effect": "Allow",
+					"Action": "ec2:AttachNetworkInterface",
+					"Resource": "*"
+				}]
+			}`),
+			},
+		},
+		ManagedPolicyArns: pulumi.StringArray{
+			pulumi.String("arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"),
+		},
+		Tags: pulumi.StringMap(CommonTags),
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	natInstanceProfile, err := iam.NewInstanceProfile(ctx, "nat-instance"+suffix+"-instance-profile", &iam.InstanceProfileArgs{
+		Name: pulumi.String(VpcConfig.Name + "-nat-instance" + suffix + "-instance-profile"),
+		Role: natInstanceRole.Name,
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	userData := pulumi.All(natEni.ID(), natEni.MacAddress).ApplyT(
+		func(args []interface{}) (string, error) {
+			return generateNatInstanceUserData(region, string(args[0].(pulumi.ID)), args[1].(string))
+		},
+	).(pulumi.StringOutput)
+
+	natLaunchTemplate, err := ec2.NewLaunchTemplate(ctx, "nat-instance"+suffix+"-launch-template", &ec2.LaunchTemplateArgs{
+		Name:         pulumi.String(VpcConfig.Name + "-nat-instance" + suffix + "-launch-template"),
+		ImageId:      pulumi.String(natAMI.Value),
+		InstanceType: pulumi.String(instanceType),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileArgs{
+			Name: natInstanceProfile.Name,
+		},
+		NetworkInterfaces: ec2.LaunchTemplateNetworkInterfaceArray{
+			&ec2.LaunchTemplateNetworkInterfaceArgs{
+				DeviceIndex:              pulumi.Int(0),
+				AssociatePublicIpAddress: pulumi.String("true"),
+				SecurityGroups:           pulumi.StringArray{natInstanceSg.ID()},
+			},
+		},
+		MetadataOptions: &ec2.LaunchTemplateMetadataOptionsArgs{
+			HttpEndpoint: pulumi.String("enabled"),
+			HttpTokens:   pulumi.String("required"),
+		},
+		TagSpecifications: ec2.LaunchTemplateTagSpecificationArray{
+			&ec2.LaunchTemplateTagSpecificationArgs{
+				ResourceType: pulumi.String("instance"),
+				Tags:         pulumi.StringMap(addNameToCommonTags(VpcConfig.Name+"-nat-instance"+suffix, CommonTags)),
+			},
+		},
+		UserData: userData,
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	_, err = autoscaling.NewGroup(ctx, "nat-instance"+suffix, &autoscaling.GroupArgs{
+		Name:            pulumi.String(VpcConfig.Name + "-nat-instance" + suffix),
+		DesiredCapacity: pulumi.Int(1),
+		MaxSize:         pulumi.Int(1),
+		MinSize:         pulumi.Int(1),
+		LaunchTemplate: &autoscaling.GroupLaunchTemplateArgs{
+			Id:      natLaunchTemplate.ID(),
+			Version: pulumi.Sprintf("%v", natLaunchTemplate.LatestVersion),
+		},
+		VpcZoneIdentifiers: pulumi.StringArray{subnet.ID()},
+		InstanceRefresh: &autoscaling.GroupInstanceRefreshArgs{
+			Strategy: pulumi.String("Rolling")},
+		Tags: autoscaling.GroupTagArray{
+			&autoscaling.GroupTagArgs{
+				Key:               pulumi.String("Name"),
+				Value:             pulumi.String(VpcConfig.Name + "-nat-instance" + suffix),
+				PropagateAtLaunch: pulumi.Bool(true),
+			},
+		},
+	})
+	if err != nil {
+		return pulumi.IDOutput{}, pulumi.StringOutput{}, err
+	}
+
+	return natEni.ID(), publicIp, nil
+}
+
+// generateNatInstanceUserData renders the boot script of the NAT instances: it attaches the static network
+// interface, enables IP forwarding and masquerades the traffic going out through that interface.
+func generateNatInstanceUserData(region string, networkInterfaceId string, macAddress string) (string, error) {
+	tplstring := `#!/bin/bash
+set -euo pipefail
+TOKEN=$(curl -sX PUT "http://169.254.169.254/latest/api/token" -H "X-aws-ec2-metadata-token-ttl-seconds: 300")
+INSTANCE_ID=$(curl -s -H "X-aws-ec2-metadata-token: $TOKEN" http://169.254.169.254/latest/meta-data/instance-id)
+aws ec2 attach-network-interface --region {{.AwsRegion}} --network-interface-id {{.NetworkInterfaceId}} --instance-id "$INSTANCE_ID" --device-index 1
+
+# Wait for the static network interface to show up
+NAT_IFACE=""
+for i in $(seq 1 60); do
+  NAT_IFACE=$(grep -il "{{.MacAddress}}" /sys/class/net/*/address | cut -d/ -f5 || true)
+  [ -n "$NAT_IFACE" ] && break
+  sleep 2
+done
+
+dnf install -y iptables-nft
+sysctl -w net.ipv4.ip_forward=1
+echo "net.ipv4.ip_forward = 1" > /etc/sysctl.d/90-nat.conf
+iptables -t nat -A POSTROUTING -o "$NAT_IFACE" -j MASQUERADE
+GATEWAY=$(ip route show default | awk '{print $3; exit}')
+ip route replace default via "$GATEWAY" dev "$NAT_IFACE" metric 1
+`
+
+	tpl, err := template.New("Template").Parse(tplstring)
+	if err != nil {
+		return "", err
+	}
+
+	tplInput := NatInstanceTemplateInput{
+		AwsRegion:          region,
+		NetworkInterfaceId: networkInterfaceId,
+		MacAddress:         macAddress,
+	}
+	var tplBytes bytes.Buffer
+	if err := tpl.Execute(&tplBytes, tplInput); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(tplBytes.Bytes()), nil
+}
@@ -0,0 +1,421 @@
+package vpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/pulumi/pulumi-aws/sdk/v5/go/aws"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+// Prefix length used for a subnet tier when azCount is set but the tier prefix is not
+const defaultSubnetPrefix = 24
+
+// planSubnets makes sure the VpcConfig has a complete and consistent subnet layout.
+// If no subnets are given explicitly, AZs are discovered through the AWS provider and
+// one private and one public subnet per AZ are carved out of the VPC CIDR block.
+// In both cases, the resulting layout is validated before any resource is created.
+func planSubnets(ctx *pulumi.Context, VpcConfig *VpcConfig) error {
+	explicit := len(VpcConfig.PrivateSubnets) > 0 || len(VpcConfig.PublicSubnets) > 0
+
+	if !explicit {
+		if VpcConfig.AzCount <= 0 {
+			return fmt.Errorf("vpc %s: either explicit subnets or azCount must be configured", VpcConfig.Name)
+		}
+		azs, err := discoverAvailabilityZones(ctx, VpcConfig.AzCount)
+		if err != nil {
+			return err
+		}
+
+		privatePrefix := VpcConfig.PrivateSubnetPrefix
+		if privatePrefix == 0 {
+			privatePrefix = defaultSubnetPrefix
+		}
+		publicPrefix := VpcConfig.PublicSubnetPrefix
+		if publicPrefix == 0 {
+			publicPrefix = defaultSubnetPrefix
+		}
+
+		// Runner subnets are only carved when asked for, after the public ones so the other subnets don't move
+		runnerPrefix := 0
+		if len(VpcConfig.RunnerSubnets) == 0 {
+			runnerPrefix = VpcConfig.RunnerSubnetPrefix
+		}
+
+		privateSubnets, publicSubnets, runnerSubnets, err := carveSubnets(VpcConfig.CidrBlock, len(azs), privatePrefix, publicPrefix, runnerPrefix)
+		if err != nil {
+			return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+		}
+		VpcConfig.PrivateSubnets = privateSubnets
+		VpcConfig.PrivateSubnetsAZ = azs
+		VpcConfig.PublicSubnets = publicSubnets
+		VpcConfig.PublicSubnetsAZ = azs
+		if len(runnerSubnets) > 0 {
+			VpcConfig.RunnerSubnets = runnerSubnets
+			VpcConfig.RunnerSubnetsAZ = azs
+		}
+	}
+
+	// Pod subnets are carved out of the first secondary CIDR block, one per private subnet AZ
+	if len(VpcConfig.SecondaryCidrBlocks) > 0 && len(VpcConfig.PodSubnets) == 0 {
+		podAZs := uniqueAZs(VpcConfig.PrivateSubnetsAZ)
+		podSubnets, err := carvePodSubnets(VpcConfig.SecondaryCidrBlocks[0], len(podAZs), VpcConfig.PodSubnetPrefix)
+		if err != nil {
+			return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+		}
+		VpcConfig.PodSubnets = podSubnets
+		VpcConfig.PodSubnetsAZ = podAZs
+	}
+
+	if err := validateSubnets(VpcConfig); err != nil {
+		return err
+	}
+	return validatePodSubnets(VpcConfig)
+}
+
+// discoverAvailabilityZones returns the first count available AZs of the region, sorted by name
+// so the result (and therefore the subnet plan) is stable between runs.
+// Local Zones and Wavelength Zones are excluded since they only support a subset of services.
+func discoverAvailabilityZones(ctx *pulumi.Context, count int) ([]string, error) {
+	availableZones, err := aws.GetAvailabilityZones(ctx, &aws.GetAvailabilityZonesArgs{
+		State: pulumi.StringRef("available"),
+		Filters: []aws.GetAvailabilityZonesFilter{
+			{
+				Name:   "opt-in-status",
+				Values: []string{"opt-in-not-required"},
+			},
+		},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	names := append([]string{}, availableZones.Names...)
+	sort.Strings(names)
+	if len(names) < count {
+		return nil, fmt.Errorf("azCount is %d but the region only has %d available AZs", count, len(names))
+	}
+	return names[:count], nil
+}
+
+// carveSubnets splits cidrBlock into count private subnets followed by count public subnets,
+// and count runner subnets when runnerPrefix is set.
+// Every subnet is aligned to its own size, so the allocation never overlaps and is deterministic
+// for a given input.
+func carveSubnets(cidrBlock string, count int, privatePrefix int, publicPrefix int, runnerPrefix int) ([]string, []string, []string, error) {
+	allocator, err := newCidrAllocator(cidrBlock)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var privateSubnets, publicSubnets, runnerSubnets []string
+	for i := 0; i < count; i++ {
+		subnet, err := allocator.allocate(privatePrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		privateSubnets = append(privateSubnets, subnet)
+	}
+	for i := 0; i < count; i++ {
+		subnet, err := allocator.allocate(publicPrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		publicSubnets = append(publicSubnets, subnet)
+	}
+	for i := 0; runnerPrefix > 0 && i < count; i++ {
+		subnet, err := allocator.allocate(runnerPrefix)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		runnerSubnets = append(runnerSubnets, subnet)
+	}
+	return privateSubnets, publicSubnets, runnerSubnets, nil
+}
+
+// carvePodSubnets splits cidrBlock into count pod subnets. When prefix is not set, the subnets
+// are as big as possible (a /16 gives two /17 or four /18, for example).
+func carvePodSubnets(cidrBlock string, count int, prefix int) ([]string, error) {
+	allocator, err := newCidrAllocator(cidrBlock)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == 0 {
+		prefix = allocator.prefix
+		for (1 << uint(prefix-allocator.prefix)) < count {
+			prefix++
+		}
+	}
+
+	var podSubnets []string
+	for i := 0; i < count; i++ {
+		subnet, err := allocator.allocate(prefix)
+		if err != nil {
+			return nil, err
+		}
+		podSubnets = append(podSubnets, subnet)
+	}
+	return podSubnets, nil
+}
+
+// validatePodSubnets checks that the secondary CIDR blocks don't overlap with the VPC CIDR block or with
+// each other, and that every pod subnet is inside a secondary block, doesn't overlap with another pod subnet
+// and is in an AZ with private subnets (pod subnets use the private route table of their AZ).
+func validatePodSubnets(VpcConfig *VpcConfig) error {
+	if len(VpcConfig.PodSubnets) != len(VpcConfig.PodSubnetsAZ) {
+		return fmt.Errorf("vpc %s: %d pod subnets but %d pod subnet AZs", VpcConfig.Name, len(VpcConfig.PodSubnets), len(VpcConfig.PodSubnetsAZ))
+	}
+	if len(VpcConfig.PodSubnets) > 0 && len(VpcConfig.SecondaryCidrBlocks) == 0 {
+		return fmt.Errorf("vpc %s: pod subnets need at least one secondary CIDR block", VpcConfig.Name)
+	}
+
+	vpcRange, err := parseCidrRange(VpcConfig.CidrBlock)
+	if err != nil {
+		return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+	}
+	var secondaryRanges []cidrRange
+	for _, secondaryCidrBlock := range VpcConfig.SecondaryCidrBlocks {
+		secondaryRange, err := parseCidrRange(secondaryCidrBlock)
+		if err != nil {
+			return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+		}
+		if secondaryRange.overlaps(vpcRange) {
+			return fmt.Errorf("vpc %s: secondary CIDR block %s overlaps with the VPC CIDR block %s", VpcConfig.Name, secondaryCidrBlock, VpcConfig.CidrBlock)
+		}
+		for index, other := range secondaryRanges {
+			if secondaryRange.overlaps(other) {
+				return fmt.Errorf("vpc %s: secondary CIDR block %s overlaps with %s", VpcConfig.Name, secondaryCidrBlock, VpcConfig.SecondaryCidrBlocks[index])
+			}
+		}
+		secondaryRanges = append(secondaryRanges, secondaryRange)
+	}
+
+	privateAZs := map[string]bool{}
+	for _, availabilityZone := range VpcConfig.PrivateSubnetsAZ {
+		privateAZs[availabilityZone] = true
+	}
+	// The ENIConfigs of the custom networking are named after the AZ of their pod subnet, so one pod subnet per AZ
+	podAZs := map[string]bool{}
+	for _, availabilityZone := range VpcConfig.PodSubnetsAZ {
+		if podAZs[availabilityZone] {
+			return fmt.Errorf("vpc %s: more than one pod subnet in %s, only one per AZ is supported", VpcConfig.Name, availabilityZone)
+		}
+		podAZs[availabilityZone] = true
+	}
+	var podRanges []cidrRange
+	for index, podSubnet := range VpcConfig.PodSubnets {
+		podRange, err := parseCidrRange(podSubnet)
+		if err != nil {
+			return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+		}
+		inside := false
+		for _, secondaryRange := range secondaryRanges {
+			if secondaryRange.contains(podRange) {
+				inside = true
+			}
+		}
+		if !inside {
+			return fmt.Errorf("vpc %s: pod subnet %s is not inside any secondary CIDR block", VpcConfig.Name, podSubnet)
+		}
+		for otherIndex, other := range podRanges {
+			if podRange.overlaps(other) {
+				return fmt.Errorf("vpc %s: pod subnet %s overlaps with pod subnet %s", VpcConfig.Name, podSubnet, VpcConfig.PodSubnets[otherIndex])
+			}
+		}
+		podRanges = append(podRanges, podRange)
+		if !privateAZs[VpcConfig.PodSubnetsAZ[index]] {
+			return fmt.Errorf("vpc %s: pod subnet %s is in %s, which has no private subnets", VpcConfig.Name, podSubnet, VpcConfig.PodSubnetsAZ[index])
+		}
+	}
+	return nil
+}
+
+// validateSubnets checks that subnet and AZ lists are aligned, that every subnet is inside
+// the VPC CIDR block and that no two subnets overlap.
+func validateSubnets(VpcConfig *VpcConfig) error {
+	if len(VpcConfig.PrivateSubnets) != len(VpcConfig.PrivateSubnetsAZ) {
+		return fmt.Errorf("vpc %s: %d private subnets but %d private subnet AZs", VpcConfig.Name, len(VpcConfig.PrivateSubnets), len(VpcConfig.PrivateSubnetsAZ))
+	}
+	if len(VpcConfig.PublicSubnets) != len(VpcConfig.PublicSubnetsAZ) {
+		return fmt.Errorf("vpc %s: %d public subnets but %d public subnet AZs", VpcConfig.Name, len(VpcConfig.PublicSubnets), len(VpcConfig.PublicSubnetsAZ))
+	}
+	if len(VpcConfig.RunnerSubnets) != len(VpcConfig.RunnerSubnetsAZ) {
+		return fmt.Errorf("vpc %s: %d runner subnets but %d runner subnet AZs", VpcConfig.Name, len(VpcConfig.RunnerSubnets), len(VpcConfig.RunnerSubnetsAZ))
+	}
+	// Runner subnets use the private route table of their AZ
+	privateAZs := map[string]bool{}
+	for _, availabilityZone := range VpcConfig.PrivateSubnetsAZ {
+		privateAZs[availabilityZone] = true
+	}
+	for index, runnerSubnetAZ := range VpcConfig.RunnerSubnetsAZ {
+		if !privateAZs[runnerSubnetAZ] {
+			return fmt.Errorf("vpc %s: runner subnet %s is in %s, which has no private subnets", VpcConfig.Name, VpcConfig.RunnerSubnets[index], runnerSubnetAZ)
+		}
+	}
+	switch natMode(VpcConfig) {
+	case "gateway", "instance":
+		if len(VpcConfig.PublicSubnets) == 0 {
+			return fmt.Errorf("vpc %s: at least one public subnet is required to host the NAT", VpcConfig.Name)
+		}
+	case "none":
+	default:
+		return fmt.Errorf("vpc %s: unknown natMode %q, must be gateway, instance or none", VpcConfig.Name, VpcConfig.NatMode)
+	}
+
+	vpcRange, err := parseCidrRange(VpcConfig.CidrBlock)
+	if err != nil {
+		return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+	}
+
+	subnets := append(append(append([]string{}, VpcConfig.PrivateSubnets...), VpcConfig.PublicSubnets...), VpcConfig.RunnerSubnets...)
+	var subnetRanges []cidrRange
+	for _, subnet := range subnets {
+		subnetRange, err := parseCidrRange(subnet)
+		if err != nil {
+			return fmt.Errorf("vpc %s: %w", VpcConfig.Name, err)
+		}
+		if !vpcRange.contains(subnetRange) {
+			return fmt.Errorf("vpc %s: subnet %s is not inside the VPC CIDR block %s", VpcConfig.Name, subnet, VpcConfig.CidrBlock)
+		}
+		for index, other := range subnetRanges {
+			if subnetRange.overlaps(other) {
+				return fmt.Errorf("vpc %s: subnet %s overlaps with subnet %s", VpcConfig.Name, subnet, subnets[index])
+			}
+		}
+		subnetRanges = append(subnetRanges, subnetRange)
+	}
+
+	if VpcConfig.EnableIpv6 && len(subnets) > 256 {
+		return fmt.Errorf("vpc %s: a /56 IPv6 block only has room for 256 /64 subnets", VpcConfig.Name)
+	}
+
+	natGateways := 1
+	if natMode(VpcConfig) == "none" {
+		natGateways = 0
+	} else if VpcConfig.NatGatewayPerAZ {
+		if _, err := natGatewaySubnets(VpcConfig); err != nil {
+			return err
+		}
+		natGateways = len(uniqueAZs(VpcConfig.PrivateSubnetsAZ))
+	}
+	if len(VpcConfig.EipAllocationIds) > 0 && len(VpcConfig.EipAllocationIds) != natGateways {
+		return fmt.Errorf("vpc %s: %d EIP allocation IDs configured but %d NATs are needed", VpcConfig.Name, len(VpcConfig.EipAllocationIds), natGateways)
+	}
+	return nil
+}
+
+// natGatewaySubnets returns, for every AZ with private subnets, the index of the public subnet
+// that hosts the NAT gateway of that AZ (the first public subnet found in it).
+func natGatewaySubnets(VpcConfig *VpcConfig) (map[string]int, error) {
+	natSubnets := map[string]int{}
+	for _, availabilityZone := range uniqueAZs(VpcConfig.PrivateSubnetsAZ) {
+		found := false
+		for index, publicSubnetAZ := range VpcConfig.PublicSubnetsAZ {
+			if publicSubnetAZ == availabilityZone {
+				natSubnets[availabilityZone] = index
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("vpc %s: private subnets in %s have no public subnet in the same AZ to host their NAT gateway", VpcConfig.Name, availabilityZone)
+		}
+	}
+	return natSubnets, nil
+}
+
+// uniqueAZs returns the AZs in the order they first appear
+func uniqueAZs(availabilityZones []string) []string {
+	seen := map[string]bool{}
+	var unique []string
+	for _, availabilityZone := range availabilityZones {
+		if !seen[availabilityZone] {
+			seen[availabilityZone] = true
+			unique = append(unique, availabilityZone)
+		}
+	}
+	return unique
+}
+
+// ipv6SubnetCidr returns the index-th /64 of the /56 IPv6 block of the VPC
+func ipv6SubnetCidr(vpcIpv6CidrBlock pulumi.StringOutput, index int) pulumi.StringOutput {
+	return vpcIpv6CidrBlock.ApplyT(func(cidr string) (string, error) {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return "", err
+		}
+		if ones, _ := network.Mask.Size(); ones != 56 {
+			return "", fmt.Errorf("expected a /56 IPv6 block for the VPC, got %s", cidr)
+		}
+		if index > 255 {
+			return "", fmt.Errorf("a /56 IPv6 block only has 256 /64 subnets")
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, network.IP.To16())
+		ip[7] = byte(index)
+		return fmt.Sprintf("%s/64", ip.String()), nil
+	}).(pulumi.StringOutput)
+}
+
+// cidrRange is an IPv4 CIDR block expressed as its first and last address
+type cidrRange struct {
+	first uint64
+	last  uint64
+}
+
+func parseCidrRange(cidr string) (cidrRange, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return cidrRange{}, err
+	}
+	ip := network.IP.To4()
+	if ip == nil {
+		return cidrRange{}, fmt.Errorf("%s is not an IPv4 CIDR block", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	first := uint64(binary.BigEndian.Uint32(ip))
+	return cidrRange{first: first, last: first + (uint64(1) << uint(bits-ones)) - 1}, nil
+}
+
+func (r cidrRange) contains(other cidrRange) bool {
+	return other.first >= r.first && other.last <= r.last
+}
+
+func (r cidrRange) overlaps(other cidrRange) bool {
+	return r.first <= other.last && other.first <= r.last
+}
+
+// cidrAllocator hands out consecutive, size-aligned blocks from a parent CIDR block
+type cidrAllocator struct {
+	parent cidrRange
+	prefix int
+	next   uint64
+}
+
+func newCidrAllocator(cidr string) (*cidrAllocator, error) {
+	parent, err := parseCidrRange(cidr)
+	if err != nil {
+		return nil, err
+	}
+	_, network, _ := net.ParseCIDR(cidr)
+	prefix, _ := network.Mask.Size()
+	return &cidrAllocator{parent: parent, prefix: prefix, next: parent.first}, nil
+}
+
+func (a *cidrAllocator) allocate(prefix int) (string, error) {
+	if prefix < a.prefix || prefix > 32 {
+		return "", fmt.Errorf("subnet prefix /%d does not fit in a /%d block", prefix, a.prefix)
+	}
+	size := uint64(1) << uint(32-prefix)
+	start := (a.next + size - 1) / size * size
+	if start+size-1 > a.parent.last {
+		return "", fmt.Errorf("no room left for a /%d subnet", prefix)
+	}
+	a.next = start + size
+
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, uint32(start))
+	return fmt.Sprintf("%s/%d", ip.String(), prefix), nil
+}
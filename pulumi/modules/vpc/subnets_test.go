@@ -0,0 +1,280 @@
+package vpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+)
+
+type mocks int
+
+func (mocks) NewResource(args pulumi.MockResourceArgs) (string, resource.PropertyMap, error) {
+	return args.Name + "_id", args.Inputs, nil
+}
+
+func (mocks) Call(args pulumi.MockCallArgs) (resource.PropertyMap, error) {
+	return args.Args, nil
+}
+
+func TestCarveSubnets(t *testing.T) {
+	tests := []struct {
+		name          string
+		cidrBlock     string
+		count         int
+		privatePrefix int
+		publicPrefix  int
+		runnerPrefix  int
+		private       []string
+		public        []string
+		runner        []string
+		wantErr       bool
+	}{
+		{
+			name:          "private then public",
+			cidrBlock:     "10.20.0.0/21",
+			count:         2,
+			privatePrefix: 24,
+			publicPrefix:  24,
+			private:       []string{"10.20.0.0/24", "10.20.1.0/24"},
+			public:        []string{"10.20.2.0/24", "10.20.3.0/24"},
+		},
+		{
+			name:          "public aligned after bigger private subnets",
+			cidrBlock:     "10.0.0.0/16",
+			count:         3,
+			privatePrefix: 20,
+			publicPrefix:  24,
+			private:       []string{"10.0.0.0/20", "10.0.16.0/20", "10.0.32.0/20"},
+			public:        []string{"10.0.48.0/24", "10.0.49.0/24", "10.0.50.0/24"},
+		},
+		{
+			name:          "smaller public subnets first, next private aligned",
+			cidrBlock:     "10.0.0.0/16",
+			count:         1,
+			privatePrefix: 26,
+			publicPrefix:  24,
+			private:       []string{"10.0.0.0/26"},
+			public:        []string{"10.0.1.0/24"},
+		},
+		{
+			name:          "runner subnets after public",
+			cidrBlock:     "10.0.0.0/16",
+			count:         2,
+			privatePrefix: 24,
+			publicPrefix:  24,
+			runnerPrefix:  20,
+			private:       []string{"10.0.0.0/24", "10.0.1.0/24"},
+			public:        []string{"10.0.2.0/24", "10.0.3.0/24"},
+			runner:        []string{"10.0.16.0/20", "10.0.32.0/20"},
+		},
+		{
+			name:          "no room left",
+			cidrBlock:     "10.20.0.0/22",
+			count:         3,
+			privatePrefix: 24,
+			publicPrefix:  24,
+			wantErr:       true,
+		},
+		{
+			name:          "prefix bigger than the block",
+			cidrBlock:     "10.20.0.0/24",
+			count:         1,
+			privatePrefix: 22,
+			publicPrefix:  24,
+			wantErr:       true,
+		},
+		{
+			name:          "invalid block",
+			cidrBlock:     "10.20.0.0",
+			count:         1,
+			privatePrefix: 24,
+			publicPrefix:  24,
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			private, public, runner, err := carveSubnets(tt.cidrBlock, tt.count, tt.privatePrefix, tt.publicPrefix, tt.runnerPrefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("carveSubnets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(private, tt.private) || !reflect.DeepEqual(public, tt.public) || !reflect.DeepEqual(runner, tt.runner) {
+				t.Errorf("carveSubnets() = %v, %v, %v, want %v, %v, %v", private, public, runner, tt.private, tt.public, tt.runner)
+			}
+		})
+	}
+}
+
+func TestIpv6SubnetCidr(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrBlock string
+		index     int
+		want      string
+		wantErr   bool
+	}{
+		{name: "first", cidrBlock: "2600:1f14:abc:de00::/56", index: 0, want: "2600:1f14:abc:de00::/64"},
+		{name: "second", cidrBlock: "2600:1f14:abc:de00::/56", index: 1, want: "2600:1f14:abc:de01::/64"},
+		{name: "last", cidrBlock: "2600:1f14:abc:de00::/56", index: 255, want: "2600:1f14:abc:deff::/64"},
+		{name: "out of the block", cidrBlock: "2600:1f14:abc:de00::/56", index: 256, wantErr: true},
+		{name: "not a /56", cidrBlock: "2600:1f14:abc:de00::/60", index: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			err := pulumi.RunErr(func(ctx *pulumi.Context) error {
+				ctx.Export("cidr", ipv6SubnetCidr(pulumi.String(tt.cidrBlock).ToStringOutput(), tt.index).ApplyT(func(cidr string) string {
+					got = cidr
+					return cidr
+				}))
+				return nil
+			}, pulumi.WithMocks("project", "stack", mocks(0)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ipv6SubnetCidr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ipv6SubnetCidr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCarvePodSubnets(t *testing.T) {
+	tests := []struct {
+		name      string
+		cidrBlock string
+		count     int
+		prefix    int
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:      "split evenly",
+			cidrBlock: "100.64.0.0/16",
+			count:     3,
+			want:      []string{"100.64.0.0/18", "100.64.64.0/18", "100.64.128.0/18"},
+		},
+		{
+			name:      "one per block",
+			cidrBlock: "100.64.0.0/16",
+			count:     1,
+			want:      []string{"100.64.0.0/16"},
+		},
+		{
+			name:      "fixed prefix",
+			cidrBlock: "100.64.0.0/16",
+			count:     2,
+			prefix:    19,
+			want:      []string{"100.64.0.0/19", "100.64.32.0/19"},
+		},
+		{
+			name:      "no room left",
+			cidrBlock: "100.64.0.0/16",
+			count:     3,
+			prefix:    17,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := carvePodSubnets(tt.cidrBlock, tt.count, tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("carvePodSubnets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("carvePodSubnets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePodSubnets(t *testing.T) {
+	tests := []struct {
+		name                string
+		secondaryCidrBlocks []string
+		podSubnets          []string
+		podSubnetsAZ        []string
+		wantErr             bool
+	}{
+		{
+			name:                "valid",
+			secondaryCidrBlocks: []string{"100.64.0.0/16", "100.65.0.0/16"},
+			podSubnets:          []string{"100.64.0.0/17", "100.65.0.0/17"},
+			podSubnetsAZ:        []string{"us-east-2a", "us-east-2b"},
+		},
+		{
+			name: "no pod subnets",
+		},
+		{
+			name:                "mismatched AZs",
+			secondaryCidrBlocks: []string{"100.64.0.0/16"},
+			podSubnets:          []string{"100.64.0.0/17", "100.64.128.0/17"},
+			podSubnetsAZ:        []string{"us-east-2a"},
+			wantErr:             true,
+		},
+		{
+			name:         "no secondary block",
+			podSubnets:   []string{"100.64.0.0/17"},
+			podSubnetsAZ: []string{"us-east-2a"},
+			wantErr:      true,
+		},
+		{
+			name:                "secondary block overlaps the VPC",
+			secondaryCidrBlocks: []string{"10.0.128.0/17"},
+			wantErr:             true,
+		},
+		{
+			name:                "overlapping secondary blocks",
+			secondaryCidrBlocks: []string{"100.64.0.0/16", "100.64.128.0/17"},
+			wantErr:             true,
+		},
+		{
+			name:                "two pod subnets in one AZ",
+			secondaryCidrBlocks: []string{"100.64.0.0/16"},
+			podSubnets:          []string{"100.64.0.0/17", "100.64.128.0/17"},
+			podSubnetsAZ:        []string{"us-east-2a", "us-east-2a"},
+			wantErr:             true,
+		},
+		{
+			name:                "pod subnet outside the secondary blocks",
+			secondaryCidrBlocks: []string{"100.64.0.0/16"},
+			podSubnets:          []string{"100.65.0.0/17"},
+			podSubnetsAZ:        []string{"us-east-2a"},
+			wantErr:             true,
+		},
+		{
+			name:                "overlapping pod subnets",
+			secondaryCidrBlocks: []string{"100.64.0.0/16"},
+			podSubnets:          []string{"100.64.0.0/17", "100.64.64.0/18"},
+			podSubnetsAZ:        []string{"us-east-2a", "us-east-2b"},
+			wantErr:             true,
+		},
+		{
+			name:                "AZ without private subnets",
+			secondaryCidrBlocks: []string{"100.64.0.0/16"},
+			podSubnets:          []string{"100.64.0.0/17"},
+			podSubnetsAZ:        []string{"us-east-2c"},
+			wantErr:             true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePodSubnets(&VpcConfig{
+				Name:                "test",
+				CidrBlock:           "10.0.0.0/16",
+				PrivateSubnetsAZ:    []string{"us-east-2a", "us-east-2b"},
+				SecondaryCidrBlocks: tt.secondaryCidrBlocks,
+				PodSubnets:          tt.podSubnets,
+				PodSubnetsAZ:        tt.podSubnetsAZ,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validatePodSubnets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
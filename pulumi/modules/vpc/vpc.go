@@ -22,14 +22,55 @@ type VpcConfig struct {
 	PrivateSubnetsAZ []string
 	PublicSubnets    []string
 	PublicSubnetsAZ  []string
-	NatGatewayPerAZ  bool
-	Tags             map[string]string
+	// When no subnets are given, AzCount AZs are discovered and the subnets
+	// are carved out of CidrBlock using the per-tier prefix lengths
+	AzCount             int
+	PrivateSubnetPrefix int
+	PublicSubnetPrefix  int
+	// "gateway" (default), "instance" or "none"
+	NatMode         string
+	NatInstanceType string
+	NatGatewayPerAZ bool
+	// Secondary CIDR blocks (e.g. 100.64.0.0/16) and the pod subnets carved out of them, used by
+	// the VPC CNI custom networking. One pod subnet per private subnet AZ is planned when they are not given
+	SecondaryCidrBlocks []string
+	PodSubnets          []string
+	PodSubnetsAZ        []string
+	PodSubnetPrefix     int
+	// Subnets dedicated to the runner nodes, routed like the private subnets of their AZ. With azCount,
+	// one per AZ is carved out of CidrBlock when RunnerSubnetPrefix is set
+	RunnerSubnets      []string
+	RunnerSubnetsAZ    []string
+	RunnerSubnetPrefix int
+	// Dual-stack VPC: Amazon-provided IPv6 block, a /64 per subnet and an Egress Only Internet Gateway
+	EnableIpv6 bool
+	// Allocation IDs of pre-allocated EIPs to use for the NAT gateways, one per NAT gateway
+	// (in private subnet AZ order). New EIPs are created when empty
+	EipAllocationIds []string
+	// Services (e.g. "ecr.api", "sts") that get an interface endpoint.
+	// Defaults to the set a private EKS cluster needs, an empty list disables them
+	InterfaceEndpoints []string
+	FlowLogs           FlowLogsConfig
+	// EKS clusters that will use the VPC, used for the load balancer and autoscaler discovery tags on the subnets
+	ClusterNames []string
+	Tags         map[string]string
 }
 
 type VpcOutput struct {
 	Vpc            *ec2.Vpc
 	PublicSubnets  []*ec2.Subnet
 	PrivateSubnets []*ec2.Subnet
+	PodSubnets     []*ec2.Subnet
+	RunnerSubnets  []*ec2.Subnet
+	// AZ of each subnet, in the order of the subnet lists
+	PrivateSubnetsAZ          []string
+	PublicSubnetsAZ           []string
+	RunnerSubnetsAZ           []string
+	S3GatewayEndpoint         *ec2.VpcEndpoint
+	VpcEndpointsSecurityGroup *ec2.SecurityGroup
+	InterfaceEndpoints        []*ec2.VpcEndpoint
+	FlowLogsDestination       pulumi.StringOutput
+	NatPublicIps              pulumi.StringArray
 }
 
 func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
@@ -39,6 +80,14 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 	conf := config.New(ctx, "")
 	conf.RequireObject("Vpc", &VpcConfig)
 
+	// Resolve and validate the subnet layout before creating anything
+	if err := planSubnets(ctx, VpcConfig); err != nil {
+		return VpcOutput{}, err
+	}
+	if err := validateFlowLogs(VpcConfig); err != nil {
+		return VpcOutput{}, err
+	}
+
 	// Create a pulumiStringMap for the Tags
 	CommonTags := pulumi.StringMap{}
 	for index, tag := range VpcConfig.Tags {
@@ -50,7 +99,12 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 	vpcTags := addNameToCommonTags(VpcConfig.Name+"-vpc", CommonTags)
 	VPC, err := ec2.NewVpc(ctx, "VPC", &ec2.VpcArgs{
 		CidrBlock: pulumi.String(VpcConfig.CidrBlock),
-		Tags:      pulumi.StringMap(vpcTags),
+		// DNS hostnames are required by the private DNS of the interface endpoints
+		EnableDnsSupport:   pulumi.Bool(true),
+		EnableDnsHostnames: pulumi.Bool(true),
+		// Amazon-provided /56 IPv6 block, for dual-stack subnets
+		AssignGeneratedIpv6CidrBlock: pulumi.Bool(VpcConfig.EnableIpv6),
+		Tags:                         pulumi.StringMap(vpcTags),
 	})
 
 	errorHandler(err)
@@ -65,6 +119,17 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 	})
 	errorHandler(err)
 
+	// Create the Egress Only Internet Gateway, so the private subnets can reach the internet over IPv6
+	var eigw *ec2.EgressOnlyInternetGateway
+	if VpcConfig.EnableIpv6 {
+		eigwTags := addNameToCommonTags(VpcConfig.Name+"-eigw", CommonTags)
+		eigw, err = ec2.NewEgressOnlyInternetGateway(ctx, "eigw", &ec2.EgressOnlyInternetGatewayArgs{
+			VpcId: VPC.ID(),
+			Tags:  pulumi.StringMap(eigwTags),
+		})
+		errorHandler(err)
+	}
+
 	// Private subnet
 
 	// Create the private subnets
@@ -73,6 +138,7 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 
 		// Create the Internet Gateway
 		subnetTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-private-subnet-0%d", index), CommonTags)
+		subnetTags = addKubernetesSubnetTags("private", VpcConfig.ClusterNames, subnetTags)
 		subnetArgs := &ec2.SubnetArgs{
 			VpcId:               VPC.ID(),
 			CidrBlock:           pulumi.String(VpcConfig.PrivateSubnets[index]),
@@ -80,6 +146,13 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 			AvailabilityZone:    pulumi.String(availabilityZone),
 			Tags:                pulumi.StringMap(subnetTags),
 		}
+		// Private subnets take the first /64s of the VPC IPv6 block.
+		// DNS64 is only useful when the NAT gateway can do NAT64 for the IPv4-only destinations
+		if VpcConfig.EnableIpv6 {
+			subnetArgs.Ipv6CidrBlock = ipv6SubnetCidr(VPC.Ipv6CidrBlock, index)
+			subnetArgs.AssignIpv6AddressOnCreation = pulumi.Bool(true)
+			subnetArgs.EnableDns64 = pulumi.Bool(natMode(VpcConfig) == "gateway")
+		}
 
 		subnet, err := ec2.NewSubnet(ctx, fmt.Sprintf("private-subnet-0%d", index), subnetArgs)
 		errorHandler(err)
@@ -94,6 +167,7 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 	for index, availabilityZone := range VpcConfig.PublicSubnetsAZ {
 
 		subnetTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-public-subnet-0%d", index), CommonTags)
+		subnetTags = addKubernetesSubnetTags("public", VpcConfig.ClusterNames, subnetTags)
 		subnetArgs := &ec2.SubnetArgs{
 			VpcId:               VPC.ID(),
 			CidrBlock:           pulumi.String(VpcConfig.PublicSubnets[index]),
@@ -101,6 +175,11 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 			AvailabilityZone:    pulumi.String(availabilityZone),
 			Tags:                pulumi.StringMap(subnetTags),
 		}
+		// Public subnets take the /64s after the private ones
+		if VpcConfig.EnableIpv6 {
+			subnetArgs.Ipv6CidrBlock = ipv6SubnetCidr(VPC.Ipv6CidrBlock, len(VpcConfig.PrivateSubnets)+index)
+			subnetArgs.AssignIpv6AddressOnCreation = pulumi.Bool(true)
+		}
 
 		subnet, err := ec2.NewSubnet(ctx, fmt.Sprintf("public-subnet-0%d", index), subnetArgs)
 		errorHandler(err)
@@ -108,104 +187,239 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 		ctx.Export(fmt.Sprintf("public-subnet-0%d", index), subnet.ID())
 	}
 
-	var natGatewayID []pulumi.IDOutput
-	// Create the nat gateway, private route tables and private route tables association
+	// Runner subnets
 
-	// If HA on NatGateways is desired, one nat gateway is created per AZ
-	// its mandatory that AZ <= public subnets
-	if VpcConfig.NatGatewayPerAZ {
+	// Create the runner subnets, they take the IPv6 /64s after the public ones
+	for index, availabilityZone := range VpcConfig.RunnerSubnetsAZ {
 
-		// for len, publicSubnetAZ := range VpcConfig.PublicSubnetsAZ{
-		for index := 0; index < len(VpcConfig.PublicSubnetsAZ); index++ {
-			// Create the EIP
-			eipTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-eip-%d", index), CommonTags)
-			eip, err := ec2.NewEip(ctx, fmt.Sprintf("eip-%d", index), &ec2.EipArgs{
-				Vpc:  pulumi.Bool(true),
-				Tags: pulumi.StringMap(eipTags),
-			})
-			errorHandler(err)
-			natGatewayTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-nat-gateway-%d", index), CommonTags)
-			natGateway, err := ec2.NewNatGateway(ctx, fmt.Sprintf("nat-gateway-%d", index), &ec2.NatGatewayArgs{
-				AllocationId: eip.AllocationId,
-				SubnetId:     VpcOutput.PublicSubnets[index].ID(),
-				Tags:         pulumi.StringMap(natGatewayTags),
-			})
-			errorHandler(err)
-			natGatewayID = append(natGatewayID, natGateway.ID())
+		subnetTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-runner-subnet-0%d", index), CommonTags)
+		subnetTags = addKubernetesSubnetTags("runner", VpcConfig.ClusterNames, subnetTags)
+		subnetArgs := &ec2.SubnetArgs{
+			VpcId:               VPC.ID(),
+			CidrBlock:           pulumi.String(VpcConfig.RunnerSubnets[index]),
+			MapPublicIpOnLaunch: pulumi.Bool(false),
+			AvailabilityZone:    pulumi.String(availabilityZone),
+			Tags:                pulumi.StringMap(subnetTags),
 		}
-		// Otherwise, only one NAT Gateway is created
-	} else {
-		// Create the EIP
-		eipTags := addNameToCommonTags(VpcConfig.Name+"-eip", CommonTags)
-		eip, err := ec2.NewEip(ctx, "eip", &ec2.EipArgs{
-			Vpc:  pulumi.Bool(true),
-			Tags: pulumi.StringMap(eipTags),
-		})
+		if VpcConfig.EnableIpv6 {
+			subnetArgs.Ipv6CidrBlock = ipv6SubnetCidr(VPC.Ipv6CidrBlock, len(VpcConfig.PrivateSubnets)+len(VpcConfig.PublicSubnets)+index)
+			subnetArgs.AssignIpv6AddressOnCreation = pulumi.Bool(true)
+			subnetArgs.EnableDns64 = pulumi.Bool(natMode(VpcConfig) == "gateway")
+		}
+
+		subnet, err := ec2.NewSubnet(ctx, fmt.Sprintf("runner-subnet-0%d", index), subnetArgs)
 		errorHandler(err)
-		natGatewayTags := addNameToCommonTags(VpcConfig.Name+"-nat-gateway", CommonTags)
-		natGateway, err := ec2.NewNatGateway(ctx, "nat-gateway", &ec2.NatGatewayArgs{
-			AllocationId: eip.AllocationId,
-			SubnetId:     VpcOutput.PublicSubnets[0].ID(),
-			Tags:         pulumi.StringMap(natGatewayTags),
-		}, pulumi.DependsOn([]pulumi.Resource{eip}))
+
+		VpcOutput.RunnerSubnets = append(VpcOutput.RunnerSubnets, subnet)
+		ctx.Export(fmt.Sprintf("runner-subnet-0%d", index), subnet.ID())
+	}
+	VpcOutput.PrivateSubnetsAZ = VpcConfig.PrivateSubnetsAZ
+	VpcOutput.PublicSubnetsAZ = VpcConfig.PublicSubnetsAZ
+	VpcOutput.RunnerSubnetsAZ = VpcConfig.RunnerSubnetsAZ
+
+	// Secondary CIDR blocks and pod subnets
+
+	// Associate the secondary CIDR blocks to the VPC
+	var secondaryCidrBlocks []pulumi.Resource
+	for index, secondaryCidrBlock := range VpcConfig.SecondaryCidrBlocks {
+		association, err := ec2.NewVpcIpv4CidrBlockAssociation(ctx, fmt.Sprintf("secondary-cidr-block-0%d", index), &ec2.VpcIpv4CidrBlockAssociationArgs{
+			VpcId:     VPC.ID(),
+			CidrBlock: pulumi.String(secondaryCidrBlock),
+		})
 		errorHandler(err)
-		natGatewayID = append(natGatewayID, natGateway.ID())
+		secondaryCidrBlocks = append(secondaryCidrBlocks, association)
 	}
 
-	// One private  RT per NAT Gateway
-	var privateRT []pulumi.IDOutput
-	for index, natgateway := range natGatewayID {
+	// Create the pod subnets, they can only be created once the secondary CIDR blocks are associated
+	for index, availabilityZone := range VpcConfig.PodSubnetsAZ {
 
-		privateRtTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-private-rt-0%d", index), CommonTags)
-		privateRt, err := ec2.NewRouteTable(ctx, fmt.Sprintf("private-rt-%d", index), &ec2.RouteTableArgs{
-			VpcId: VPC.ID(),
-			Routes: ec2.RouteTableRouteArray{
-				&ec2.RouteTableRouteArgs{
-					// Connect to the internet through the Internet Gateway
-					// If the IP is not within the CIDR block range of the private subnet
-					CidrBlock:    pulumi.String("0.0.0.0/0"),
-					NatGatewayId: natgateway,
-				},
-			},
-			Tags: pulumi.StringMap(privateRtTags),
-		})
+		subnetTags := addNameToCommonTags(VpcConfig.Name+fmt.Sprintf("-pod-subnet-0%d", index), CommonTags)
+		subnetArgs := &ec2.SubnetArgs{
+			VpcId:               VPC.ID(),
+			CidrBlock:           pulumi.String(VpcConfig.PodSubnets[index]),
+			MapPublicIpOnLaunch: pulumi.Bool(false),
+			AvailabilityZone:    pulumi.String(availabilityZone),
+			Tags:                pulumi.StringMap(subnetTags),
+		}
+
+		subnet, err := ec2.NewSubnet(ctx, fmt.Sprintf("pod-subnet-0%d", index), subnetArgs, pulumi.DependsOn(secondaryCidrBlocks))
 		errorHandler(err)
-		privateRT = append(privateRT, privateRt.ID())
 
+		VpcOutput.PodSubnets = append(VpcOutput.PodSubnets, subnet)
+		ctx.Export(fmt.Sprintf("pod-subnet-0%d", index), subnet.ID())
 	}
 
-	// Private subnet route table association:
-	// Each RT is assigned to private subnet until there are no more routing tables, then we assign first one
-	// This solves for both multi AZ Nat Gateway and single Nat Gateway
-	for index, privatesubnetids := range VpcOutput.PrivateSubnets {
-		if index >= len(privateRT) {
-			_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("private-subnet-rt-assoc-0%d", index), &ec2.RouteTableAssociationArgs{
-				RouteTableId: privateRT[0],
-				SubnetId:     privatesubnetids.ID(),
-			})
+	// Create the NAT (gateways, instances or nothing depending on the NAT mode), private route tables and private route tables association.
+	// Everything is keyed by availability zone, so private subnets always go through a NAT in their own AZ
+	privateAZs := uniqueAZs(VpcConfig.PrivateSubnetsAZ)
+	natRoutes := map[string]ec2.RouteTableRouteArray{}
+	// Index of the private route table of each AZ when they were named by index, before NAT instances existed
+	legacyRtIndexes := map[string]int{}
+
+	switch natMode(VpcConfig) {
+	case "gateway", "instance":
+		// If HA on NAT is desired, one NAT is created per private subnet AZ, hosted in a public subnet of that same AZ.
+		// Otherwise, only one NAT is created, in the first public subnet, and shared by every AZ
+		natSubnets := map[string]int{}
+		natSuffixes := map[string]string{}
+		natAZs := privateAZs
+		if VpcConfig.NatGatewayPerAZ {
+			// Already validated by planSubnets
+			var err error
+			natSubnets, err = natGatewaySubnets(VpcConfig)
 			errorHandler(err)
+			for _, availabilityZone := range privateAZs {
+				natSuffixes[availabilityZone] = "-" + availabilityZone
+			}
 		} else {
-			_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("private-subnet-rt-assoc-0%d", index), &ec2.RouteTableAssociationArgs{
-				RouteTableId: privateRT[index],
-				SubnetId:     privatesubnetids.ID(),
+			natAZs = []string{VpcConfig.PublicSubnetsAZ[0]}
+		}
+
+		natRoute := map[string]ec2.RouteTableRouteArray{}
+		for index, availabilityZone := range natAZs {
+			suffix := natSuffixes[availabilityZone]
+			subnet := VpcOutput.PublicSubnets[natSubnets[availabilityZone]]
+
+			if natMode(VpcConfig) == "gateway" {
+				// The NAT gateways per AZ used to be named after the index of their public subnet, the single one had no suffix
+				legacyIndex := -1
+				if VpcConfig.NatGatewayPerAZ {
+					legacyIndex = natSubnets[availabilityZone]
+					legacyRtIndexes[availabilityZone] = legacyIndex
+				} else {
+					legacyRtIndexes[privateAZs[0]] = 0
+				}
+				// Create or adopt the EIP
+				allocationId, publicIp, err := natEip(ctx, VpcConfig, CommonTags, suffix, index, legacyIndex)
+				errorHandler(err)
+				natGatewayTags := addNameToCommonTags(VpcConfig.Name+"-nat-gateway"+suffix, CommonTags)
+				natGateway, err := ec2.NewNatGateway(ctx, "nat-gateway"+suffix, &ec2.NatGatewayArgs{
+					AllocationId: allocationId,
+					SubnetId:     subnet.ID(),
+					Tags:         pulumi.StringMap(natGatewayTags),
+				}, legacyAliases("nat-gateway", legacyIndex))
+				errorHandler(err)
+				VpcOutput.NatPublicIps = append(VpcOutput.NatPublicIps, publicIp)
+				natRoute[availabilityZone] = ec2.RouteTableRouteArray{
+					&ec2.RouteTableRouteArgs{
+						// Connect to the internet through the NAT Gateway
+						// If the IP is not within the CIDR block range of the VPC
+						CidrBlock:    pulumi.String("0.0.0.0/0"),
+						NatGatewayId: natGateway.ID(),
+					},
+				}
+				if VpcConfig.EnableIpv6 {
+					// NAT64, for IPv6 clients reaching IPv4-only destinations through DNS64
+					natRoute[availabilityZone] = append(natRoute[availabilityZone], &ec2.RouteTableRouteArgs{
+						Ipv6CidrBlock: pulumi.String("64:ff9b::/96"),
+						NatGatewayId:  natGateway.ID(),
+					})
+				}
+			} else {
+				natEniID, publicIp, err := createNatInstance(ctx, VpcConfig, CommonTags, VPC, subnet, conf.Require("region"), suffix, index)
+				errorHandler(err)
+				VpcOutput.NatPublicIps = append(VpcOutput.NatPublicIps, publicIp)
+				natRoute[availabilityZone] = ec2.RouteTableRouteArray{
+					&ec2.RouteTableRouteArgs{
+						// Connect to the internet through the NAT instance
+						// If the IP is not within the CIDR block range of the VPC
+						CidrBlock:          pulumi.String("0.0.0.0/0"),
+						NetworkInterfaceId: natEniID,
+					},
+				}
+			}
+		}
+
+		for _, availabilityZone := range privateAZs {
+			if VpcConfig.NatGatewayPerAZ {
+				natRoutes[availabilityZone] = natRoute[availabilityZone]
+			} else {
+				natRoutes[availabilityZone] = natRoute[natAZs[0]]
+			}
+		}
+
+	case "none":
+		// Fully private VPC, the private subnets only reach AWS services through the VPC endpoints
+	}
+
+	// IPv6 egress goes through the Egress Only Internet Gateway in every NAT mode
+	if VpcConfig.EnableIpv6 {
+		for _, availabilityZone := range privateAZs {
+			natRoutes[availabilityZone] = append(natRoutes[availabilityZone], &ec2.RouteTableRouteArgs{
+				Ipv6CidrBlock:       pulumi.String("::/0"),
+				EgressOnlyGatewayId: eigw.ID(),
 			})
-			errorHandler(err)
 		}
 	}
 
+	// One private RT per AZ, routing through the NAT of that AZ (wired the same way in every NAT mode)
+	var privateRT []pulumi.IDOutput
+	privateRTByAZ := map[string]pulumi.IDOutput{}
+	for _, availabilityZone := range privateAZs {
+
+		privateRtTags := addNameToCommonTags(VpcConfig.Name+"-private-rt-"+availabilityZone, CommonTags)
+		legacyRtIndex, ok := legacyRtIndexes[availabilityZone]
+		if !ok {
+			legacyRtIndex = -1
+		}
+		privateRt, err := ec2.NewRouteTable(ctx, "private-rt-"+availabilityZone, &ec2.RouteTableArgs{
+			VpcId:  VPC.ID(),
+			Routes: natRoutes[availabilityZone],
+			Tags:   pulumi.StringMap(privateRtTags),
+		}, legacyAliases("private-rt", legacyRtIndex))
+		errorHandler(err)
+		privateRT = append(privateRT, privateRt.ID())
+		privateRTByAZ[availabilityZone] = privateRt.ID()
+	}
+
+	// Private subnet route table association:
+	// Each private subnet is associated to the route table of its own AZ
+	for index, privatesubnetids := range VpcOutput.PrivateSubnets {
+		_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("private-subnet-rt-assoc-0%d", index), &ec2.RouteTableAssociationArgs{
+			RouteTableId: privateRTByAZ[VpcConfig.PrivateSubnetsAZ[index]],
+			SubnetId:     privatesubnetids.ID(),
+		})
+		errorHandler(err)
+	}
+
+	// Runner subnets share the private route table of their AZ
+	for index, runnersubnetids := range VpcOutput.RunnerSubnets {
+		_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("runner-subnet-rt-assoc-0%d", index), &ec2.RouteTableAssociationArgs{
+			RouteTableId: privateRTByAZ[VpcConfig.RunnerSubnetsAZ[index]],
+			SubnetId:     runnersubnetids.ID(),
+		})
+		errorHandler(err)
+	}
+
+	// Pod subnets share the private route table of their AZ
+	for index, podsubnetids := range VpcOutput.PodSubnets {
+		_, err = ec2.NewRouteTableAssociation(ctx, fmt.Sprintf("pod-subnet-rt-assoc-0%d", index), &ec2.RouteTableAssociationArgs{
+			RouteTableId: privateRTByAZ[VpcConfig.PodSubnetsAZ[index]],
+			SubnetId:     podsubnetids.ID(),
+		})
+		errorHandler(err)
+	}
+
 	// Create the public route table
 	publicRtTags := addNameToCommonTags(VpcConfig.Name+"-public-rt", CommonTags)
-	publicRt, err := ec2.NewRouteTable(ctx, "public-rt", &ec2.RouteTableArgs{
-		VpcId: VPC.ID(),
-		Routes: ec2.RouteTableRouteArray{
-			&ec2.RouteTableRouteArgs{
-				// Connect to the internet through the Internet Gateway
-				// If the IP is not within the CIDR block range of the VPC
-				CidrBlock: pulumi.String("0.0.0.0/0"),
-				GatewayId: igw.ID(),
-			},
+	publicRoutes := ec2.RouteTableRouteArray{
+		&ec2.RouteTableRouteArgs{
+			// Connect to the internet through the Internet Gateway
+			// If the IP is not within the CIDR block range of the VPC
+			CidrBlock: pulumi.String("0.0.0.0/0"),
+			GatewayId: igw.ID(),
 		},
-		Tags: pulumi.StringMap(publicRtTags),
+	}
+	if VpcConfig.EnableIpv6 {
+		publicRoutes = append(publicRoutes, &ec2.RouteTableRouteArgs{
+			Ipv6CidrBlock: pulumi.String("::/0"),
+			GatewayId:     igw.ID(),
+		})
+	}
+	publicRt, err := ec2.NewRouteTable(ctx, "public-rt", &ec2.RouteTableArgs{
+		VpcId:  VPC.ID(),
+		Routes: publicRoutes,
+		Tags:   pulumi.StringMap(publicRtTags),
 	})
 	errorHandler(err)
 
@@ -226,7 +440,7 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 	// Create the VPC endpoint to S3 (Gateway).
 	// This should be added by default since it has no cost associated and it makes regional s3 data transfer internal and free
 	vpcEndpointTags := addNameToCommonTags(VpcConfig.Name+"-vpc-s3-endpoint", CommonTags)
-	_, err = ec2.NewVpcEndpoint(ctx, "s3-vpc-gateway-endpoint", &ec2.VpcEndpointArgs{
+	VpcOutput.S3GatewayEndpoint, err = ec2.NewVpcEndpoint(ctx, "s3-vpc-gateway-endpoint", &ec2.VpcEndpointArgs{
 		VpcId:         VPC.ID(),
 		ServiceName:   pulumi.String("com.amazonaws." + conf.Require("region") + ".s3"),
 		RouteTableIds: routeTables,
@@ -235,8 +449,26 @@ func CreateVPC(ctx *pulumi.Context) (VpcOutput, error) {
 
 	errorHandler(err)
 
+	// Create the interface VPC endpoints (ECR, STS, EC2, SSM, Logs...) in the private subnets.
+	// This keeps image pulls, IRSA token exchange and SSM sessions off the NAT gateway
+	VpcOutput.VpcEndpointsSecurityGroup, VpcOutput.InterfaceEndpoints, err = createInterfaceEndpoints(ctx, VpcConfig, CommonTags, VPC, VpcOutput.PrivateSubnets, conf.Require("region"))
+	errorHandler(err)
+
+	// Send the VPC flow logs to S3 or CloudWatch, if enabled
+	if VpcConfig.FlowLogs.Enabled {
+		VpcOutput.FlowLogsDestination, err = createFlowLogs(ctx, VpcConfig, CommonTags, VPC)
+		errorHandler(err)
+		ctx.Export("flow-logs-destination", VpcOutput.FlowLogsDestination)
+	}
+
+	// Export the egress IPs, so they can be added to the GitHub organization IP allow list
+	ctx.Export("nat-public-ips", VpcOutput.NatPublicIps)
 	ctx.Export("vpc", VPC.ID())
 	ctx.Export("igw-id", igw.ID())
+	if VpcConfig.EnableIpv6 {
+		ctx.Export("vpc-ipv6-cidr-block", VPC.Ipv6CidrBlock)
+		ctx.Export("eigw-id", eigw.ID())
+	}
 
 	return *VpcOutput, nil
 }
@@ -249,3 +481,86 @@ func addNameToCommonTags(name string, commonTags pulumi.StringMap) pulumi.String
 	tagsWithName["Name"] = pulumi.String(name)
 	return tagsWithName
 }
+
+// legacyAliases aliases a resource keyed by AZ to its former "<name>-<index>" name, so existing stacks keep it.
+// No alias when the index is negative.
+func legacyAliases(name string, legacyIndex int) pulumi.ResourceOption {
+	aliases := []pulumi.Alias{}
+	if legacyIndex >= 0 {
+		aliases = append(aliases, pulumi.Alias{Name: pulumi.String(fmt.Sprintf("%s-%d", name, legacyIndex))})
+	}
+	return pulumi.Aliases(aliases)
+}
+
+// natEip returns the allocation ID and the public IP of the EIP of a NAT gateway or NAT instance.
+// Pre-allocated EIPs are adopted when their allocation IDs are configured, otherwise a new EIP is created.
+// Created EIPs are protected, since replacing them would change the egress IPs allowed in GitHub.
+// legacyIndex is the former index of the EIP, -1 when it had none.
+func natEip(ctx *pulumi.Context, VpcConfig *VpcConfig, CommonTags pulumi.StringMap, suffix string, index int, legacyIndex int) (pulumi.StringOutput, pulumi.StringOutput, error) {
+	if len(VpcConfig.EipAllocationIds) > 0 {
+		allocationId := VpcConfig.EipAllocationIds[index]
+		eip, err := ec2.GetElasticIp(ctx, &ec2.GetElasticIpArgs{
+			Id: pulumi.StringRef(allocationId),
+		}, nil)
+		if err != nil {
+			return pulumi.StringOutput{}, pulumi.StringOutput{}, err
+		}
+		return pulumi.String(allocationId).ToStringOutput(), pulumi.String(eip.PublicIp).ToStringOutput(), nil
+	}
+
+	eipTags := addNameToCommonTags(VpcConfig.Name+"-eip"+suffix, CommonTags)
+	eip, err := ec2.NewEip(ctx, "eip"+suffix, &ec2.EipArgs{
+		Vpc:  pulumi.Bool(true),
+		Tags: pulumi.StringMap(eipTags),
+	}, pulumi.Protect(true), legacyAliases("eip", legacyIndex))
+	if err != nil {
+		return pulumi.StringOutput{}, pulumi.StringOutput{}, err
+	}
+	return eip.AllocationId, eip.PublicIp, nil
+}
+
+// vpcCidrBlocks returns the primary and secondary CIDR blocks of the VPC, for security group rules
+// that have to allow traffic from nodes and pods alike
+func vpcCidrBlocks(VpcConfig *VpcConfig, VPC *ec2.Vpc) pulumi.StringArray {
+	cidrBlocks := pulumi.StringArray{VPC.CidrBlock}
+	for _, secondaryCidrBlock := range VpcConfig.SecondaryCidrBlocks {
+		cidrBlocks = append(cidrBlocks, pulumi.String(secondaryCidrBlock))
+	}
+	return cidrBlocks
+}
+
+// natMode returns the configured NAT mode, defaulting to NAT gateways
+func natMode(VpcConfig *VpcConfig) string {
+	if VpcConfig.NatMode == "" {
+		return "gateway"
+	}
+	return VpcConfig.NatMode
+}
+
+// addKubernetesSubnetTags adds the tags used by the AWS Load Balancer Controller and the autoscalers to discover
+// the subnets: the tier role (public subnets for internet-facing load balancers, private ones for internal load balancers, none for the runner ones)
+// and one kubernetes.io/cluster/<name> tag per cluster. Karpenter discovers the private and runner subnets through karpenter.sh/discovery,
+// which can only hold one value, so it's set to the first cluster.
+func addKubernetesSubnetTags(tier string, clusterNames []string, tags pulumi.StringMap) pulumi.StringMap {
+	if len(clusterNames) == 0 {
+		return tags
+	}
+	subnetTags := pulumi.StringMap{}
+	for k, v := range tags {
+		subnetTags[k] = v
+	}
+	switch tier {
+	case "public":
+		subnetTags["kubernetes.io/role/elb"] = pulumi.String("1")
+	case "runner":
+		// Only nodes go in the runner subnets, no load balancer
+		subnetTags["karpenter.sh/discovery"] = pulumi.String(clusterNames[0])
+	default:
+		subnetTags["kubernetes.io/role/internal-elb"] = pulumi.String("1")
+		subnetTags["karpenter.sh/discovery"] = pulumi.String(clusterNames[0])
+	}
+	for _, clusterName := range clusterNames {
+		subnetTags["kubernetes.io/cluster/"+clusterName] = pulumi.String("shared")
+	}
+	return subnetTags
+}